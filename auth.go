@@ -0,0 +1,165 @@
+package zipkinproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// A key a client needs to send spans to the proxy.
+type apiKey struct {
+	// name of the key, used in metrics instead of the key itself
+	Name string
+	Key  string
+
+	// services this key is allowed to send spans for. All services are allowed if empty.
+	Services map[string]bool
+}
+
+// Parses an api key in the format 'name:key' or 'name:key:service,service,...'.
+func parseApiKey(value string) (*apiKey, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("expected api key in format name:key[:services], got %q", value)
+	}
+
+	key := &apiKey{Name: parts[0], Key: parts[1]}
+
+	if len(parts) == 3 {
+		key.Services = map[string]bool{}
+		for _, service := range strings.Split(parts[2], ",") {
+			if service = strings.TrimSpace(service); service != "" {
+				key.Services[service] = true
+			}
+		}
+	}
+
+	return key, nil
+}
+
+// Checks if the key is allowed to send spans of the given service.
+func (key *apiKey) Allows(service string) bool {
+	return key == nil || len(key.Services) == 0 || key.Services[service]
+}
+
+type apiKeyContextKey struct{}
+
+// Returns the api key the request was authenticated with, or nil.
+func apiKeyFromContext(ctx context.Context) *apiKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	return key
+}
+
+// Checks the api key of requests. Authentication is disabled if no keys are configured.
+type authenticator struct {
+	keys []*apiKey
+}
+
+func newAuthenticator(keys []*apiKey) *authenticator {
+	return &authenticator{keys: keys}
+}
+
+// Looks up the key sent with the request, either as bearer token,
+// in the X-Api-Key header or in the api_key query parameter.
+func (auth *authenticator) lookup(req *http.Request) *apiKey {
+	value := req.Header.Get("X-Api-Key")
+
+	if value == "" {
+		value = bearerToken(req.Header.Get("Authorization"))
+	}
+
+	if value == "" {
+		value = req.URL.Query().Get("api_key")
+	}
+
+	return auth.find(value)
+}
+
+// Looks up the key sent in the metadata of a grpc call,
+// either in the x-api-key entry or as bearer token.
+func (auth *authenticator) lookupMetadata(md metadata.MD) *apiKey {
+	var value string
+
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		value = values[0]
+	}
+
+	if values := md.Get("authorization"); value == "" && len(values) > 0 {
+		value = bearerToken(values[0])
+	}
+
+	return auth.find(value)
+}
+
+// Returns the token of an authorization header using the bearer scheme.
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return ""
+}
+
+// Returns the key with the given value, or nil.
+func (auth *authenticator) find(value string) *apiKey {
+	if value == "" {
+		return nil
+	}
+
+	var found *apiKey
+
+	// compare with all keys to not leak anything through timing
+	for _, key := range auth.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+			found = key
+		}
+	}
+
+	return found
+}
+
+// Wraps the handler so it is only called for requests with a valid api key.
+func (auth *authenticator) Handle(handle httprouter.Handle) httprouter.Handle {
+	if len(auth.keys) == 0 {
+		return handle
+	}
+
+	return func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		key := auth.lookup(req)
+		if key == nil {
+			metrics.GetOrRegisterMeter("requests.unauthorized[route:"+req.URL.Path+"]", nil).Mark(1)
+
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "missing or invalid api key", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), apiKeyContextKey{}, key)
+		handle(writer, req.WithContext(ctx), params)
+	}
+}
+
+// Checks the api key of a grpc call and returns a context that holds the key.
+// Fails with codes.Unauthenticated if the key is missing or invalid.
+func (auth *authenticator) AuthenticateGrpc(ctx context.Context) (context.Context, error) {
+	if len(auth.keys) == 0 {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	key := auth.lookupMetadata(md)
+	if key == nil {
+		metrics.GetOrRegisterMeter("requests.unauthorized[route:otlp-grpc]", nil).Mark(1)
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid api key")
+	}
+
+	return context.WithValue(ctx, apiKeyContextKey{}, key), nil
+}
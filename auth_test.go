@@ -0,0 +1,131 @@
+package zipkinproxy
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseApiKey(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	key, err := parseApiKey("team-a:secret")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).To(Equal(&apiKey{Name: "team-a", Key: "secret"}))
+	g.Expect(key.Allows("any-service")).To(BeTrue())
+
+	key, err = parseApiKey("team-b:secret:orders, payments")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key.Allows("orders")).To(BeTrue())
+	g.Expect(key.Allows("payments")).To(BeTrue())
+	g.Expect(key.Allows("users")).To(BeFalse())
+
+	_, err = parseApiKey("secret")
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = parseApiKey(":secret")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestAuthenticator(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 16)
+
+	keys := []*apiKey{
+		{Name: "team-a", Key: "secret-a"},
+		{Name: "team-b", Key: "secret-b", Services: map[string]bool{"orders": true}},
+	}
+
+	router := httprouter.New()
+	handleSpans(router, newSpanQueue(spans, time.Second, 0), newAuthenticator(keys))
+
+	body := `[
+		{"traceId": "beaf", "id": "dead", "localEndpoint": {"serviceName": "orders"}},
+		{"traceId": "beaf", "id": "cafe", "localEndpoint": {"serviceName": "users"}}
+	]`
+
+	post := func(url string, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// no or invalid key
+	g.Expect(post("/api/v2/spans", "", "").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(post("/api/v2/spans", "Authorization", "Bearer secret-c").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(spans).To(BeEmpty())
+
+	// all services allowed
+	g.Expect(post("/api/v2/spans", "Authorization", "Bearer secret-a").Code).To(Equal(http.StatusAccepted))
+	g.Expect(post("/api/v2/spans", "X-Api-Key", "secret-a").Code).To(Equal(http.StatusAccepted))
+	g.Expect(post("/api/v2/spans?api_key=secret-a", "", "").Code).To(Equal(http.StatusAccepted))
+	g.Expect(spans).To(HaveLen(6))
+
+	// only one of the services is allowed
+	response := post("/api/v2/spans", "X-Api-Key", "secret-b")
+	g.Expect(response.Code).To(Equal(http.StatusAccepted))
+
+	var result ingestResult
+	g.Expect(json.Unmarshal(response.Body.Bytes(), &result)).To(Succeed())
+	g.Expect(result.Accepted).To(Equal(1))
+	g.Expect(result.Rejected).To(Equal(1))
+	g.Expect(result.Rejections[0].Reason).To(ContainSubstring("users"))
+
+	g.Expect(spans).To(HaveLen(7))
+}
+
+func TestAuthenticator_Grpc(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	keys := []*apiKey{{Name: "team-a", Key: "secret-a"}}
+
+	call := func(auth *authenticator, pairs ...string) (*apiKey, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+
+		ctx, err := auth.AuthenticateGrpc(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return apiKeyFromContext(ctx), nil
+	}
+
+	// no or invalid key
+	_, err := call(newAuthenticator(keys))
+	g.Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+
+	_, err = call(newAuthenticator(keys), "x-api-key", "secret-b")
+	g.Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+
+	// the key is passed on in the context
+	key, err := call(newAuthenticator(keys), "x-api-key", "secret-a")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).To(Equal(keys[0]))
+
+	key, err = call(newAuthenticator(keys), "authorization", "Bearer secret-a")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).To(Equal(keys[0]))
+
+	// without keys, calls are not authenticated
+	key, err = call(newAuthenticator(nil))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(key).To(BeNil())
+}
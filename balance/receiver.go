@@ -14,11 +14,39 @@ var log = logrus.WithField("prefix", "balance")
 
 type SpanCallback func(proxy.Span)
 
+// Receives all spans of a kafka message at once.
+type SpansCallback func([]proxy.Span)
+
+// Consumes the spans from the internal topic the Sender writes to.
 func Consume(consumerGroup sarama.ConsumerGroup, topic string, callback SpanCallback) func() {
+	handler := &consumerGroupHandler{
+		decode: decodeKafkaMessage,
+		callback: func(spans []proxy.Span) {
+			for _, span := range spans {
+				callback(span)
+			}
+		},
+	}
+
+	return consume(consumerGroup, topic, handler)
+}
+
+// Consumes lists of spans that zipkin reporters publish to the given topic.
+// The encoding is one of the codec.Encoding* constants, codec.EncodingAuto
+// detects the encoding for each message. The spans of a message are passed to the
+// callback together, the message is committed once the callback returns.
+func ConsumeZipkin(consumerGroup sarama.ConsumerGroup, topic string, encoding string, callback SpansCallback) func() {
 	handler := &consumerGroupHandler{
 		callback: callback,
+		decode: func(message *sarama.ConsumerMessage) ([]proxy.Span, error) {
+			return decodeZipkinMessage(message, encoding)
+		},
 	}
 
+	return consume(consumerGroup, topic, handler)
+}
+
+func consume(consumerGroup sarama.ConsumerGroup, topic string, handler *consumerGroupHandler) func() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	finishedCh := make(chan bool)
@@ -44,14 +72,28 @@ func Consume(consumerGroup sarama.ConsumerGroup, topic string, callback SpanCall
 	}
 }
 
-func decodeKafkaMessage(message *sarama.ConsumerMessage) (proxy.Span, error) {
+func decodeKafkaMessage(message *sarama.ConsumerMessage) ([]proxy.Span, error) {
 	proxySpan, err := codec.BinaryDecode(bytes.NewReader(message.Value))
-	return proxySpan, errors.WithMessage(err, "deserialize avro message")
+	if err != nil {
+		return nil, errors.WithMessage(err, "deserialize avro message")
+	}
+
+	return []proxy.Span{proxySpan}, nil
+}
+
+func decodeZipkinMessage(message *sarama.ConsumerMessage, encoding string) ([]proxy.Span, error) {
+	spans, err := codec.ParseZipkin(encoding, message.Value)
+	if err != nil {
+		return nil, errors.WithMessage(err, "deserialize zipkin message")
+	}
+
+	return spans, nil
 }
 
 // Represents a Sarama consumer group consumer
 type consumerGroupHandler struct {
-	callback SpanCallback
+	callback SpansCallback
+	decode   func(message *sarama.ConsumerMessage) ([]proxy.Span, error)
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
@@ -80,13 +122,13 @@ func (consumer *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupS
 	for message := range claim.Messages() {
 		// log.Debugf("Got message on topic %s:%d (%d bytes)", message.Topic, message.Partition, len(message.Value))
 
-		proxySpan, err := decodeKafkaMessage(message)
+		proxySpans, err := consumer.decode(message)
 		if err != nil {
 			log.Warnf("Cannot deserialize kafka message: %s", err)
 			continue
 		}
 
-		consumer.callback(proxySpan)
+		consumer.callback(proxySpans)
 
 		session.MarkMessage(message, "")
 	}
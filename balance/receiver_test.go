@@ -0,0 +1,29 @@
+package balance
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/flachnetz/dd-zipkin-proxy/codec"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestDecodeZipkinMessage(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	message := &sarama.ConsumerMessage{
+		Value: []byte(`[{"traceId": "beaf", "id": "dead", "name": "get", "timestamp": 1560276900000000, "duration": 1000,
+			"localEndpoint": {"serviceName": "my-service"}}]`),
+	}
+
+	for _, encoding := range []string{codec.EncodingAuto, codec.EncodingJsonV2} {
+		spans, err := decodeZipkinMessage(message, encoding)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(spans).To(HaveLen(1))
+		g.Expect(spans[0].Id).To(Equal(proxy.Id(0xdead)))
+		g.Expect(spans[0].Service).To(Equal("my-service"))
+	}
+
+	_, err := decodeZipkinMessage(message, codec.EncodingThrift)
+	g.Expect(err).To(HaveOccurred())
+}
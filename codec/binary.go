@@ -2,6 +2,7 @@ package codec
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/flachnetz/dd-zipkin-proxy/cache"
 	"github.com/flachnetz/dd-zipkin-proxy/proxy"
@@ -60,6 +61,36 @@ func readMapString(r *bytes.Reader) (map[string]string, error) {
 	return m, nil
 }
 
+// Reads a map of doubles, returns nil for an empty map.
+func readMapDouble(r *bytes.Reader) (map[string]float64, error) {
+	count, err := readLong(r)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	// each entry needs at least nine bytes
+	if count < 0 || count > int64(r.Len()) {
+		return nil, fmt.Errorf("map size out of range: %d", count)
+	}
+
+	m := make(map[string]float64, count)
+	for i := int64(0); i < count; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+
+		m[key] = math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+	}
+
+	return m, nil
+}
+
 func readId(r *bytes.Reader) (Id, error) {
 	id, err := readLong(r)
 	return Id(id), err
@@ -75,6 +106,151 @@ func readDuration(r *bytes.Reader) (time.Duration, error) {
 	return time.Duration(value), err
 }
 
+func readKind(r *bytes.Reader) (proxy.Kind, error) {
+	value, err := readLong(r)
+	return proxy.Kind(value), err
+}
+
+func readEvents(r *bytes.Reader) ([]proxy.Event, error) {
+	count, err := readLong(r)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	// each event needs at least three bytes
+	if count < 0 || count > int64(r.Len()) {
+		return nil, fmt.Errorf("event count out of range: %d", count)
+	}
+
+	events := make([]proxy.Event, count)
+	for idx := range events {
+		event := &events[idx]
+
+		event.Timestamp, err = readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Name, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Tags, err = readMapString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(event.Tags) == 0 {
+			event.Tags = nil
+		}
+	}
+
+	return events, nil
+}
+
+func readLinks(r *bytes.Reader) ([]proxy.Link, error) {
+	count, err := readLong(r)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	// each link needs at least five bytes
+	if count < 0 || count > int64(r.Len()) {
+		return nil, fmt.Errorf("link count out of range: %d", count)
+	}
+
+	links := make([]proxy.Link, count)
+	for idx := range links {
+		link := &links[idx]
+
+		link.Trace.High, err = readId(r)
+		if err != nil {
+			return nil, err
+		}
+		link.Trace.Low, err = readId(r)
+		if err != nil {
+			return nil, err
+		}
+		link.Span, err = readId(r)
+		if err != nil {
+			return nil, err
+		}
+
+		followsFrom, err := readLong(r)
+		if err != nil {
+			return nil, err
+		}
+
+		link.FollowsFrom = followsFrom != 0
+
+		link.Tags, err = readMapString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(link.Tags) == 0 {
+			link.Tags = nil
+		}
+	}
+
+	return links, nil
+}
+
+func readSampling(r *bytes.Reader) (proxy.Sampling, error) {
+	var sampling proxy.Sampling
+
+	decided, err := readLong(r)
+	if err != nil {
+		return sampling, err
+	}
+	priority, err := readLong(r)
+	if err != nil {
+		return sampling, err
+	}
+	debug, err := readLong(r)
+	if err != nil {
+		return sampling, err
+	}
+
+	sampling.Decided = decided != 0
+	sampling.Priority = proxy.SamplingPriority(priority)
+	sampling.Debug = debug != 0
+
+	return sampling, nil
+}
+
+func readEndpoint(r *bytes.Reader) (*proxy.Endpoint, error) {
+	present, err := readLong(r)
+	if err != nil || present == 0 {
+		return nil, err
+	}
+
+	var endpoint proxy.Endpoint
+
+	endpoint.ServiceName, err = readString(r)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.IPv4, err = readString(r)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.IPv6, err = readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := readLong(r)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Port = int(port)
+
+	return &endpoint, nil
+}
+
 func BinaryDecode(r *bytes.Reader) (proxy.Span, error) {
 	var span = proxy.Span{}
 	var err error
@@ -127,6 +303,86 @@ func BinaryDecode(r *bytes.Reader) (proxy.Span, error) {
 		return span, err
 	}
 
+	// fields added later are appended to the end. Messages
+	// written by older versions simply end earlier.
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.TraceHigh, err = readId(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.Kind, err = readKind(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.Events, err = readEvents(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.LocalEndpoint, err = readEndpoint(r)
+	if err != nil {
+		return span, err
+	}
+	span.RemoteEndpoint, err = readEndpoint(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.Metrics, err = readMapDouble(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.Links, err = readLinks(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	span.Sampling, err = readSampling(r)
+	if err != nil {
+		return span, err
+	}
+
+	if r.Len() == 0 {
+		return span, nil
+	}
+
+	shared, err := readLong(r)
+	if err != nil {
+		return span, err
+	}
+
+	span.Shared = shared != 0
+
 	return span, nil
 }
 
@@ -218,6 +474,138 @@ func writeMapString(r map[string]string, w *bytes.Buffer) error {
 	return writeLong(0, w)
 }
 
+func writeMapDouble(m map[string]float64, w *bytes.Buffer) error {
+	err := writeLong(int64(len(m)), w)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range m {
+		err = writeString(key, w)
+		if err != nil {
+			return err
+		}
+
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(value))
+		_, err = w.Write(buf[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeEvents(events []proxy.Event, w *bytes.Buffer) error {
+	err := writeLong(int64(len(events)), w)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		err = writeLong(int64(event.Timestamp), w)
+		if err != nil {
+			return err
+		}
+		err = writeString(event.Name, w)
+		if err != nil {
+			return err
+		}
+		err = writeMapString(event.Tags, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeLinks(links []proxy.Link, w *bytes.Buffer) error {
+	err := writeLong(int64(len(links)), w)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		err = writeLong(int64(link.Trace.High), w)
+		if err != nil {
+			return err
+		}
+		err = writeLong(int64(link.Trace.Low), w)
+		if err != nil {
+			return err
+		}
+		err = writeLong(int64(link.Span), w)
+		if err != nil {
+			return err
+		}
+
+		var followsFrom int64
+		if link.FollowsFrom {
+			followsFrom = 1
+		}
+
+		err = writeLong(followsFrom, w)
+		if err != nil {
+			return err
+		}
+		err = writeMapString(link.Tags, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSampling(sampling proxy.Sampling, w *bytes.Buffer) error {
+	var decided, debug int64
+	if sampling.Decided {
+		decided = 1
+	}
+
+	if sampling.Debug {
+		debug = 1
+	}
+
+	err := writeLong(decided, w)
+	if err != nil {
+		return err
+	}
+	err = writeLong(int64(sampling.Priority), w)
+	if err != nil {
+		return err
+	}
+
+	return writeLong(debug, w)
+}
+
+func writeEndpoint(endpoint *proxy.Endpoint, w *bytes.Buffer) error {
+	if endpoint == nil {
+		return writeLong(0, w)
+	}
+
+	err := writeLong(1, w)
+	if err != nil {
+		return err
+	}
+	err = writeString(endpoint.ServiceName, w)
+	if err != nil {
+		return err
+	}
+	err = writeString(endpoint.IPv4, w)
+	if err != nil {
+		return err
+	}
+	err = writeString(endpoint.IPv6, w)
+	if err != nil {
+		return err
+	}
+
+	return writeLong(int64(endpoint.Port), w)
+}
+
 func BinaryEncode(r proxy.Span, w *bytes.Buffer) error {
 	var err error
 	err = writeLong(int64(r.Id), w)
@@ -268,6 +656,48 @@ func BinaryEncode(r proxy.Span, w *bytes.Buffer) error {
 	if err != nil {
 		return err
 	}
+	err = writeLong(int64(r.TraceHigh), w)
+	if err != nil {
+		return err
+	}
+	err = writeLong(int64(r.Kind), w)
+	if err != nil {
+		return err
+	}
+	err = writeEvents(r.Events, w)
+	if err != nil {
+		return err
+	}
+	err = writeEndpoint(r.LocalEndpoint, w)
+	if err != nil {
+		return err
+	}
+	err = writeEndpoint(r.RemoteEndpoint, w)
+	if err != nil {
+		return err
+	}
+	err = writeMapDouble(r.Metrics, w)
+	if err != nil {
+		return err
+	}
+	err = writeLinks(r.Links, w)
+	if err != nil {
+		return err
+	}
+	err = writeSampling(r.Sampling, w)
+	if err != nil {
+		return err
+	}
+
+	var shared int64
+	if r.Shared {
+		shared = 1
+	}
+
+	err = writeLong(shared, w)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
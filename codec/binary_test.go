@@ -14,6 +14,7 @@ var binaryTestSpan = proxy.Span{
 	Parent:  0xbeaf,
 	Name:    "span name",
 	Service: "my-service",
+	Kind:    proxy.KindClient,
 
 	// timestamp is also picked from the CS/CR if available
 	Timestamp: proxy.Timestamp(1560276970 * time.Second),
@@ -42,6 +43,105 @@ func TestBinaryEncoding(t *testing.T) {
 	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(binaryTestSpan))
 }
 
+func TestBinaryEncoding_TraceId128(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.TraceHigh = 0x463ac35c9f6413ad
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
+func TestBinaryDecode_OlderVersions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(binaryTestSpan, &buf)).ToNot(HaveOccurred())
+
+	// messages of older versions end before the fields that were added later.
+	// Each of the trailing fields is encoded in one byte here.
+	encoded := buf.Bytes()
+
+	expected := binaryTestSpan
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-1]))).To(Equal(expected))
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-4]))).To(Equal(expected))
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-5]))).To(Equal(expected))
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-6]))).To(Equal(expected))
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-8]))).To(Equal(expected))
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-9]))).To(Equal(expected))
+
+	expected.Kind = proxy.KindUnspecified
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-10]))).To(Equal(expected))
+
+	expected.TraceHigh = 0
+	g.Expect(BinaryDecode(bytes.NewReader(encoded[:len(encoded)-11]))).To(Equal(expected))
+}
+
+func TestBinaryEncoding_Events(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.Events = []proxy.Event{
+		{Timestamp: proxy.Timestamp(1560276970 * time.Second), Name: "ws"},
+		{Timestamp: proxy.Timestamp(1560276971 * time.Second), Name: "error", Tags: map[string]string{"message": "failed"}},
+	}
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
+func TestBinaryEncoding_Endpoints(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.LocalEndpoint = &proxy.Endpoint{ServiceName: "my-service", IPv4: "10.0.0.1"}
+	span.RemoteEndpoint = &proxy.Endpoint{ServiceName: "postgres", IPv6: "::1", Port: 5432}
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
+func TestBinaryEncoding_Metrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.Metrics = map[string]float64{"http.status": 404, "ratio": -0.25}
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
+func TestBinaryEncoding_Links(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.Links = []proxy.Link{
+		{Trace: proxy.TraceId{High: 1, Low: 0xcccc}, Span: 0xbbbb, FollowsFrom: true},
+		{Trace: proxy.TraceId{Low: 0xbeaf}, Span: 0xaaaa, Tags: map[string]string{"reason": "batch"}},
+	}
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
+func TestBinaryEncoding_Sampling(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := binaryTestSpan
+	span.Sampling = proxy.Sampling{Decided: true, Priority: proxy.PriorityUserReject, Debug: true}
+	span.Shared = true
+
+	var buf bytes.Buffer
+	g.Expect(BinaryEncode(span, &buf)).ToNot(HaveOccurred())
+	g.Expect(BinaryDecode(bytes.NewReader(buf.Bytes()))).To(Equal(span))
+}
+
 func BenchmarkBinaryDecode(b *testing.B) {
 	var buf bytes.Buffer
 	_ = BinaryEncode(binaryTestSpan, &buf)
@@ -0,0 +1,186 @@
+package codec
+
+import (
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+	"io"
+	"strconv"
+	"time"
+)
+
+var msgpackHandle = newMsgpackHandle()
+
+// tag used by datadog tracers to transport the upper 64 bits of a 128 bit trace id
+const datadogTagTraceIdHigh = "_dd.p.tid"
+
+// tag containing the span events encoded as json
+const datadogTagEvents = "events"
+
+// metric containing the sampling priority of the trace
+const datadogMetricSamplingPriority = "_sampling_priority_v1"
+
+type datadogEvent struct {
+	TimeUnixNano int64                  `json:"time_unix_nano"`
+	Name         string                 `json:"name"`
+	Attributes   map[string]interface{} `json:"attributes"`
+}
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	handle := &codec.MsgpackHandle{}
+
+	// some tracers encode strings as raw bytes
+	handle.RawToString = true
+
+	return handle
+}
+
+// A span as sent by datadog tracers to the trace agent.
+type datadogSpan struct {
+	Name     string `json:"name"`
+	Service  string `json:"service"`
+	Resource string `json:"resource"`
+	Type     string `json:"type"`
+
+	// start and duration in nanoseconds
+	Start    int64 `json:"start"`
+	Duration int64 `json:"duration"`
+
+	Meta    map[string]string  `json:"meta"`
+	Metrics map[string]float64 `json:"metrics"`
+
+	SpanID   uint64 `json:"span_id"`
+	TraceID  uint64 `json:"trace_id"`
+	ParentID uint64 `json:"parent_id"`
+
+	Error int32 `json:"error"`
+}
+
+// Parses a list of traces encoded using msgpack, as sent by datadog
+// tracers to the /v0.3/traces and /v0.4/traces endpoints of the trace agent.
+func ParseDatadogMsgpack(input io.Reader) ([]proxy.Span, error) {
+	var traces [][]datadogSpan
+	if err := codec.NewDecoder(input, msgpackHandle).Decode(&traces); err != nil {
+		return nil, errors.WithMessage(err, "parse spans for datadog msgpack")
+	}
+
+	return datadogTracesToSpans(traces), nil
+}
+
+// Parses a list of traces encoded as json. Older tracers send those to /v0.3/traces.
+func ParseDatadogJson(input io.Reader) ([]proxy.Span, error) {
+	var traces [][]datadogSpan
+	if err := json.NewDecoder(input).Decode(&traces); err != nil {
+		return nil, errors.WithMessage(err, "parse spans for datadog json")
+	}
+
+	return datadogTracesToSpans(traces), nil
+}
+
+func datadogTracesToSpans(traces [][]datadogSpan) []proxy.Span {
+	var parsedSpans []proxy.Span
+	for _, trace := range traces {
+		for idx := range trace {
+			parsedSpans = append(parsedSpans, trace[idx].ToSpan())
+		}
+	}
+
+	return parsedSpans
+}
+
+func (span *datadogSpan) ToSpan() proxy.Span {
+	// the datadog sink sends the name of the span as resource,
+	// so we take the resource as name to get the same span back out.
+	name := span.Resource
+	if name == "" {
+		name = span.Name
+	}
+
+	proxySpan := proxy.NewSpan(name, Id(span.TraceID), Id(span.SpanID), Id(span.ParentID))
+	proxySpan.Service = span.Service
+
+	proxySpan.Tags = make(map[string]string, 2+len(span.Meta)+len(span.Metrics))
+
+	for key, value := range span.Meta {
+		if key == datadogTagTraceIdHigh {
+			if high, err := proxy.ParseId([]byte(value)); err == nil {
+				proxySpan.TraceHigh = high
+				continue
+			}
+		}
+
+		if key == datadogTagEvents {
+			if events, err := parseDatadogEvents(value); err == nil {
+				proxySpan.Events = events
+				continue
+			}
+		}
+
+		proxySpan.AddTag(key, value)
+	}
+
+	for key, value := range span.Metrics {
+		if key == datadogMetricSamplingPriority {
+			proxySpan.Sampling.SetPriority(proxy.SamplingPriority(value))
+			continue
+		}
+
+		proxySpan.AddTag(key, strconv.FormatFloat(value, 'f', -1, 64))
+		proxySpan.AddMetric(key, value)
+	}
+
+	if span.Type != "" {
+		proxySpan.AddTag("span.type", span.Type)
+	}
+
+	if span.Error != 0 {
+		proxySpan.AddTag("error", "true")
+	}
+
+	proxySpan.AddTag(tagProtocolVersion, tagDatadog)
+
+	proxySpan.Timestamp = proxy.Timestamp(span.Start)
+	proxySpan.Duration = time.Duration(span.Duration)
+
+	if proxySpan.Duration <= 0 {
+		proxySpan.Duration = 1 * time.Millisecond
+	}
+
+	proxySpan.Kind = proxy.ParseKind(span.Meta["span.kind"])
+	fillInTimings(&proxySpan)
+
+	return proxySpan
+}
+
+func parseDatadogEvents(encoded string) ([]proxy.Event, error) {
+	var decoded []datadogEvent
+	if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+		return nil, err
+	}
+
+	events := make([]proxy.Event, 0, len(decoded))
+	for _, event := range decoded {
+		converted := proxy.Event{
+			Timestamp: proxy.Timestamp(event.TimeUnixNano),
+			Name:      event.Name,
+		}
+
+		for key, value := range event.Attributes {
+			if converted.Tags == nil {
+				converted.Tags = make(map[string]string, len(event.Attributes))
+			}
+
+			if text, ok := value.(string); ok {
+				converted.Tags[key] = text
+			} else {
+				encoded, _ := json.Marshal(value)
+				converted.Tags[key] = string(encoded)
+			}
+		}
+
+		events = append(events, converted)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,138 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"github.com/ugorji/go/codec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDatadogMsgpack(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var encoded bytes.Buffer
+	err := codec.NewEncoder(&encoded, msgpackHandle).Encode([][]datadogSpan{{datadogTestSpan}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	spans, err := ParseDatadogMsgpack(&encoded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(Equal([]proxy.Span{expectedDatadogSpan}))
+}
+
+func TestParseDatadogJson(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseDatadogJson(strings.NewReader(`[[{
+		"name": "http.request", "service": "core-services", "resource": "GET /users",
+		"type": "web", "start": 1560276970000000000, "duration": 1000000000,
+		"meta": {"span.kind": "server"}, "metrics": {"db.rows": 42},
+		"span_id": 48815, "trace_id": 57005, "parent_id": 43690, "error": 1
+	}]]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(Equal([]proxy.Span{expectedDatadogSpan}))
+}
+
+func TestParseDatadogJson_TraceId128(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseDatadogJson(strings.NewReader(`[[{
+		"name": "http.request", "service": "core-services", "span_id": 48815, "trace_id": 57005,
+		"meta": {"_dd.p.tid": "463ac35c9f6413ad"}
+	}]]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].TraceHigh).To(Equal(proxy.Id(0x463ac35c9f6413ad)))
+	g.Expect(spans[0].Trace).To(Equal(proxy.Id(0xdead)))
+	g.Expect(spans[0].Tags).ToNot(HaveKey("_dd.p.tid"))
+}
+
+func TestParseDatadogJson_SamplingPriority(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseDatadogJson(strings.NewReader(`[[{
+		"name": "http.request", "service": "core-services", "span_id": 48815, "trace_id": 57005,
+		"metrics": {"_sampling_priority_v1": -1}
+	}]]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].Sampling).To(Equal(proxy.Sampling{Decided: true, Priority: proxy.PriorityUserReject}))
+	g.Expect(spans[0].Metrics).To(BeEmpty())
+}
+
+func TestParseDatadogJson_Events(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseDatadogJson(strings.NewReader(`[[{
+		"name": "http.request", "service": "core-services", "span_id": 48815, "trace_id": 57005,
+		"meta": {"events": "[{\"time_unix_nano\": 1560276970000000000, \"name\": \"retry\", \"attributes\": {\"attempt\": 2, \"reason\": \"timeout\"}}]"}
+	}]]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].Tags).ToNot(HaveKey("events"))
+	g.Expect(spans[0].Events).To(Equal([]proxy.Event{
+		{
+			Timestamp: proxy.Timestamp(1560276970 * time.Second),
+			Name:      "retry",
+			Tags:      map[string]string{"attempt": "2", "reason": "timeout"},
+		},
+	}))
+}
+
+func TestParseDatadogMsgpack_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := ParseDatadogMsgpack(bytes.NewReader([]byte{0x91, 0x91, 0xc1}))
+	g.Expect(err).To(HaveOccurred())
+}
+
+var datadogTestSpan = datadogSpan{
+	Name:     "http.request",
+	Service:  "core-services",
+	Resource: "GET /users",
+	Type:     "web",
+
+	Start:    1560276970 * int64(time.Second),
+	Duration: int64(time.Second),
+
+	Meta:    map[string]string{"span.kind": "server"},
+	Metrics: map[string]float64{"db.rows": 42},
+
+	SpanID:   0xbeaf,
+	TraceID:  0xdead,
+	ParentID: 0xaaaa,
+
+	Error: 1,
+}
+
+var expectedDatadogSpan = proxy.Span{
+	Id:      0xbeaf,
+	Trace:   0xdead,
+	Parent:  0xaaaa,
+	Name:    "GET /users",
+	Service: "core-services",
+	Kind:    proxy.KindServer,
+
+	Timestamp: proxy.Timestamp(1560276970 * time.Second),
+	Duration:  1000 * time.Millisecond,
+
+	Tags: map[string]string{
+		"span.kind":        "server",
+		"span.type":        "web",
+		"db.rows":          "42",
+		"error":            "true",
+		tagProtocolVersion: tagDatadog,
+	},
+	Metrics: map[string]float64{"db.rows": 42},
+
+	Timings: proxy.Timings{
+		SR: proxy.Timestamp(1560276970 * time.Second),
+		SS: proxy.Timestamp(1560276971 * time.Second),
+	},
+}
@@ -30,6 +30,18 @@ func Uint64ValueDecoder(target unsafe.Pointer, p *Parser) error {
 	return nil
 }
 
+func BoolValueDecoder(target unsafe.Pointer, p *Parser) error {
+	tok, err := p.ReadBoolean()
+	if err != nil {
+		return errors.WithMessage(err, "decode bool value")
+	}
+
+	// assign to target
+	*(*bool)(target) = tok.Value[0] == 't'
+
+	return nil
+}
+
 func StringValueDecoder(target unsafe.Pointer, p *Parser) error {
 	tok, err := p.ReadString()
 	if err != nil {
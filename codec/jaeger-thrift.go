@@ -0,0 +1,364 @@
+package codec
+
+import (
+	"encoding/base64"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// tag value types of jaeger.thrift
+const (
+	jaegerTagTypeString = 0
+	jaegerTagTypeDouble = 1
+	jaegerTagTypeBool   = 2
+	jaegerTagTypeLong   = 3
+	jaegerTagTypeBinary = 4
+)
+
+// Parses an emitBatch call of the jaeger agent protocol encoded using the thrift
+// compact protocol. This is what jaeger clients send to the agent via udp.
+func ParseJaegerThriftCompact(input io.Reader) ([]proxy.Span, error) {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read jaeger thrift body")
+	}
+
+	r := &thriftCompactReader{buf: body}
+
+	method, err := r.ReadMessageBegin()
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse spans for jaeger thrift")
+	}
+
+	if method != "emitBatch" {
+		return nil, errors.Errorf("unexpected method %q in jaeger agent message", method)
+	}
+
+	var parsedSpans []proxy.Span
+
+	// the arguments of the emitBatch call
+	err = readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		if fieldId == 1 && fieldType == thriftTypeStruct {
+			return readJaegerThriftBatch(r, &parsedSpans)
+		}
+
+		return skipThrift(r, fieldType)
+	})
+
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse spans for jaeger thrift")
+	}
+
+	return parsedSpans, nil
+}
+
+// Parses a jaeger Batch encoded using the thrift binary protocol. This is
+// what jaeger clients send when configured with a collector endpoint.
+func ParseJaegerThriftBinary(input io.Reader) ([]proxy.Span, error) {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read jaeger thrift body")
+	}
+
+	var parsedSpans []proxy.Span
+
+	r := &thriftBinaryReader{buf: body}
+	if err := readJaegerThriftBatch(r, &parsedSpans); err != nil {
+		return nil, errors.WithMessage(err, "parse spans for jaeger thrift")
+	}
+
+	return parsedSpans, nil
+}
+
+func readJaegerThriftBatch(r thriftReader, result *[]proxy.Span) error {
+	var process jaegerProcess
+	var spans []jaegerSpan
+
+	err := readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeStruct:
+			return errors.WithMessage(readJaegerThriftProcess(r, &process), "process")
+
+		case fieldId == 2 && fieldType == thriftTypeList:
+			err := readThriftStructList(r, func(r thriftReader) error {
+				var span jaegerSpan
+				if err := readJaegerThriftSpan(r, &span); err != nil {
+					return err
+				}
+
+				spans = append(spans, span)
+				return nil
+			})
+
+			return errors.WithMessage(err, "spans")
+
+		default:
+			return skipThrift(r, fieldType)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for idx := range spans {
+		proxySpan := spans[idx].ToSpan(process)
+		proxySpan.AddTag(tagProtocolVersion, tagJaegerThrift)
+
+		*result = append(*result, proxySpan)
+	}
+
+	return nil
+}
+
+func readJaegerThriftProcess(r thriftReader, process *jaegerProcess) error {
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeString:
+			process.ServiceName, err = r.ReadString()
+
+		case fieldId == 2 && fieldType == thriftTypeList:
+			process.Tags, err = readJaegerThriftTags(r)
+			err = errors.WithMessage(err, "process tags")
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+}
+
+func readJaegerThriftSpan(r thriftReader, span *jaegerSpan) error {
+	var parentSpanId Id
+
+	err := readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.TraceId.Low = Id(value)
+
+		case fieldId == 2 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.TraceId.High = Id(value)
+
+		case fieldId == 3 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.SpanId = Id(value)
+
+		case fieldId == 4 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			parentSpanId = Id(value)
+
+		case fieldId == 5 && fieldType == thriftTypeString:
+			span.OperationName, err = r.ReadString()
+
+		case fieldId == 6 && fieldType == thriftTypeList:
+			err = readThriftStructList(r, func(r thriftReader) error {
+				var reference jaegerReference
+				if err := readJaegerThriftReference(r, &reference); err != nil {
+					return err
+				}
+
+				span.References = append(span.References, reference)
+				return nil
+			})
+
+			err = errors.WithMessage(err, "references")
+
+		case fieldId == 7 && fieldType == thriftTypeI32:
+			var value int32
+			value, err = r.ReadI32()
+			span.Flags = uint32(value)
+
+		case fieldId == 8 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.Timestamp = uint64(value)
+
+		case fieldId == 9 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.Duration = uint64(value)
+
+		case fieldId == 10 && fieldType == thriftTypeList:
+			span.Tags, err = readJaegerThriftTags(r)
+			err = errors.WithMessage(err, "tags")
+
+		case fieldId == 11 && fieldType == thriftTypeList:
+			err = readThriftStructList(r, func(r thriftReader) error {
+				var log jaegerLog
+				if err := readJaegerThriftLog(r, &log); err != nil {
+					return err
+				}
+
+				span.Logs = append(span.Logs, log)
+				return nil
+			})
+
+			err = errors.WithMessage(err, "logs")
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// the parent is not always given as reference
+	if parentSpanId != 0 {
+		parent := jaegerReference{RefType: "CHILD_OF", TraceId: span.TraceId, SpanId: parentSpanId}
+		span.References = append([]jaegerReference{parent}, span.References...)
+	}
+
+	return nil
+}
+
+func readJaegerThriftReference(r thriftReader, reference *jaegerReference) error {
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI32:
+			refType, err := r.ReadI32()
+			if refType == 1 {
+				reference.RefType = "FOLLOWS_FROM"
+			} else {
+				reference.RefType = "CHILD_OF"
+			}
+
+			return err
+
+		case fieldId == 2 && fieldType == thriftTypeI64:
+			value, err := r.ReadI64()
+			reference.TraceId.Low = Id(value)
+			return err
+
+		case fieldId == 3 && fieldType == thriftTypeI64:
+			value, err := r.ReadI64()
+			reference.TraceId.High = Id(value)
+			return err
+
+		case fieldId == 4 && fieldType == thriftTypeI64:
+			value, err := r.ReadI64()
+			reference.SpanId = Id(value)
+			return err
+
+		default:
+			return skipThrift(r, fieldType)
+		}
+	})
+}
+
+func readJaegerThriftLog(r thriftReader, log *jaegerLog) error {
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			log.Timestamp = uint64(value)
+
+		case fieldId == 2 && fieldType == thriftTypeList:
+			log.Fields, err = readJaegerThriftTags(r)
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+}
+
+func readJaegerThriftTags(r thriftReader) ([]jaegerTag, error) {
+	var tags []jaegerTag
+
+	err := readThriftStructList(r, func(r thriftReader) error {
+		tag, err := readJaegerThriftTag(r)
+		if err != nil {
+			return err
+		}
+
+		tags = append(tags, tag)
+		return nil
+	})
+
+	return tags, err
+}
+
+// Reads a tag. Numbers are kept as int64 or float64, all other values are formatted as string.
+func readJaegerThriftTag(r thriftReader) (jaegerTag, error) {
+	var tag jaegerTag
+	var tagType int32
+
+	var valueString string
+	var valueDouble float64
+	var valueBool bool
+	var valueLong int64
+	var valueBinary []byte
+
+	err := readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeString:
+			tag.Key, err = r.ReadString()
+
+		case fieldId == 2 && fieldType == thriftTypeI32:
+			tagType, err = r.ReadI32()
+
+		case fieldId == 3 && fieldType == thriftTypeString:
+			valueString, err = r.ReadString()
+
+		case fieldId == 4 && fieldType == thriftTypeDouble:
+			valueDouble, err = r.ReadDouble()
+
+		case fieldId == 5 && fieldType == thriftTypeBool:
+			valueBool, err = r.ReadBool()
+
+		case fieldId == 6 && fieldType == thriftTypeI64:
+			valueLong, err = r.ReadI64()
+
+		case fieldId == 7 && fieldType == thriftTypeString:
+			valueBinary, err = r.ReadBinary()
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+
+	switch tagType {
+	case jaegerTagTypeString:
+		tag.Value = valueString
+
+	case jaegerTagTypeDouble:
+		tag.Value = valueDouble
+
+	case jaegerTagTypeBool:
+		tag.Value = strconv.FormatBool(valueBool)
+
+	case jaegerTagTypeLong:
+		tag.Value = valueLong
+
+	case jaegerTagTypeBinary:
+		tag.Value = base64.StdEncoding.EncodeToString(valueBinary)
+	}
+
+	return tag, err
+}
@@ -0,0 +1,438 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseJaegerThriftCompact(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJaegerThriftCompact(bytes.NewReader(encodedJaegerThriftCompact()))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(2))
+
+	g.Expect(spans[0]).To(Equal(proxy.Span{
+		Id:      0xbeaf,
+		Trace:   0xdead,
+		Parent:  0xaaaa,
+		Name:    "getconnection",
+		Service: "core-services",
+		Kind:    proxy.KindClient,
+
+		// sampled and debug flag
+		Sampling: proxy.Sampling{Decided: true, Priority: proxy.PriorityAutoKeep, Debug: true},
+
+		LocalEndpoint:  &proxy.Endpoint{ServiceName: "core-services", IPv4: "10.0.0.1"},
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "postgres", IPv4: "10.0.0.2", Port: 5432},
+
+		Timestamp: proxy.Timestamp(1560276970 * time.Second),
+		Duration:  1000 * time.Millisecond,
+
+		Tags: map[string]string{
+			"lc":               "postgres",
+			"db.rows":          "42",
+			"db.ratio":         "0.25",
+			"db.cached":        "true",
+			"error.type":       "SQLException",
+			"error.msg":        "connection refused",
+			tagProtocolVersion: tagJaegerThrift,
+		},
+		Metrics: map[string]float64{"db.rows": 42, "db.ratio": 0.25},
+
+		Events: []proxy.Event{
+			{
+				Timestamp: proxy.Timestamp(1560276970*time.Second + 500*time.Millisecond),
+				Name:      "error",
+				Tags: map[string]string{
+					"error.kind": "SQLException",
+					"message":    "connection refused",
+				},
+			},
+		},
+
+		Timings: proxy.Timings{
+			CS: proxy.Timestamp(1560276970 * time.Second),
+			CR: proxy.Timestamp(1560276971 * time.Second),
+		},
+	}))
+
+	// span with only a FOLLOWS_FROM reference is a root, the reference is kept as link
+	g.Expect(spans[1].Id).To(Equal(proxy.Id(0xcccc)))
+	g.Expect(spans[1].IsRoot()).To(BeTrue())
+	g.Expect(spans[1].Service).To(Equal("core-services"))
+	g.Expect(spans[1].Links).To(Equal([]proxy.Link{
+		{Trace: proxy.TraceId{Low: 0xdead}, Span: 0xbeaf, FollowsFrom: true},
+	}))
+}
+
+func TestParseJaegerThriftCompact_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := encodedJaegerThriftCompact()
+
+	// truncated input must not panic
+	for idx := 0; idx < len(encoded); idx++ {
+		_, err := ParseJaegerThriftCompact(bytes.NewReader(encoded[:idx]))
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+// A minimal writer for the thrift compact protocol to generate test input.
+type thriftCompactWriter struct {
+	bytes.Buffer
+
+	lastFieldId  int16
+	lastFieldIds []int16
+}
+
+// maps the type ids of the binary protocol to the ones of the compact protocol.
+var thriftCompactTypeIds = map[byte]byte{
+	thriftTypeByte:   3,
+	thriftTypeI16:    4,
+	thriftTypeI32:    5,
+	thriftTypeI64:    6,
+	thriftTypeDouble: 7,
+	thriftTypeString: 8,
+	thriftTypeList:   9,
+	thriftTypeSet:    10,
+	thriftTypeMap:    11,
+	thriftTypeStruct: 12,
+}
+
+func (w *thriftCompactWriter) Message(name string) {
+	w.WriteByte(thriftCompactProtocolId)
+	w.WriteByte(thriftCompactVersion | 4<<5)
+	w.varint(1)
+	w.String(name)
+}
+
+func (w *thriftCompactWriter) fieldHeader(compactType byte, fieldId int16) {
+	if delta := fieldId - w.lastFieldId; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | compactType)
+	} else {
+		w.WriteByte(compactType)
+		w.zigzag(int64(fieldId))
+	}
+
+	w.lastFieldId = fieldId
+}
+
+func (w *thriftCompactWriter) Field(fieldType byte, fieldId int16) {
+	w.fieldHeader(thriftCompactTypeIds[fieldType], fieldId)
+}
+
+func (w *thriftCompactWriter) BoolField(fieldId int16, value bool) {
+	if value {
+		w.fieldHeader(1, fieldId)
+	} else {
+		w.fieldHeader(2, fieldId)
+	}
+}
+
+func (w *thriftCompactWriter) StructBegin() {
+	w.lastFieldIds = append(w.lastFieldIds, w.lastFieldId)
+	w.lastFieldId = 0
+}
+
+func (w *thriftCompactWriter) Stop() {
+	w.WriteByte(thriftTypeStop)
+
+	w.lastFieldId = w.lastFieldIds[len(w.lastFieldIds)-1]
+	w.lastFieldIds = w.lastFieldIds[:len(w.lastFieldIds)-1]
+}
+
+func (w *thriftCompactWriter) List(elementType byte, size int) {
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | thriftCompactTypeIds[elementType])
+	} else {
+		w.WriteByte(0xf0 | thriftCompactTypeIds[elementType])
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftCompactWriter) varint(value uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], value)])
+}
+
+func (w *thriftCompactWriter) zigzag(value int64) {
+	w.varint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (w *thriftCompactWriter) I32(value int32) {
+	w.zigzag(int64(value))
+}
+
+func (w *thriftCompactWriter) I64(value int64) {
+	w.zigzag(value)
+}
+
+func (w *thriftCompactWriter) Double(value float64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(value))
+	w.Write(buf[:])
+}
+
+func (w *thriftCompactWriter) String(value string) {
+	w.varint(uint64(len(value)))
+	w.WriteString(value)
+}
+
+func encodedJaegerThriftCompact() []byte {
+	var w thriftCompactWriter
+
+	tag := func(key string, tagType int32, value func()) {
+		w.StructBegin()
+		w.Field(thriftTypeString, 1)
+		w.String(key)
+		w.Field(thriftTypeI32, 2)
+		w.I32(tagType)
+		value()
+		w.Stop()
+	}
+
+	stringTag := func(key, value string) {
+		tag(key, jaegerTagTypeString, func() {
+			w.Field(thriftTypeString, 3)
+			w.String(value)
+		})
+	}
+
+	reference := func(refType int32, spanId int64) {
+		w.StructBegin()
+		w.Field(thriftTypeI32, 1)
+		w.I32(refType)
+		w.Field(thriftTypeI64, 2)
+		w.I64(0xdead)
+		w.Field(thriftTypeI64, 3)
+		w.I64(0)
+		w.Field(thriftTypeI64, 4)
+		w.I64(spanId)
+		w.Stop()
+	}
+
+	w.Message("emitBatch")
+
+	// emitBatch arguments
+	w.StructBegin()
+	w.Field(thriftTypeStruct, 1)
+
+	// the batch
+	w.StructBegin()
+	w.Field(thriftTypeStruct, 1)
+
+	// the process
+	w.StructBegin()
+	w.Field(thriftTypeString, 1)
+	w.String("core-services")
+	w.Field(thriftTypeList, 2)
+	w.List(thriftTypeStruct, 2)
+	stringTag("hostname", "localhost")
+	tag("ip", jaegerTagTypeLong, func() {
+		w.Field(thriftTypeI64, 6)
+		w.I64(0x0a000001)
+	})
+	w.Stop()
+
+	w.Field(thriftTypeList, 2)
+	w.List(thriftTypeStruct, 2)
+
+	// first span
+	w.StructBegin()
+	w.Field(thriftTypeI64, 1)
+	w.I64(0xdead)
+	w.Field(thriftTypeI64, 2)
+	w.I64(0)
+	w.Field(thriftTypeI64, 3)
+	w.I64(0xbeaf)
+	w.Field(thriftTypeI64, 4)
+	w.I64(0xaaaa)
+	w.Field(thriftTypeString, 5)
+	w.String("getconnection")
+	w.Field(thriftTypeI32, 7)
+	w.I32(3)
+	w.Field(thriftTypeI64, 8)
+	w.I64(1560276970000000)
+	w.Field(thriftTypeI64, 9)
+	w.I64(1000000)
+
+	w.Field(thriftTypeList, 10)
+	w.List(thriftTypeStruct, 8)
+	stringTag("component", "postgres")
+	stringTag("span.kind", "client")
+	stringTag("peer.service", "postgres")
+	stringTag("peer.ipv4", "10.0.0.2")
+	tag("peer.port", jaegerTagTypeLong, func() {
+		w.Field(thriftTypeI64, 6)
+		w.I64(5432)
+	})
+	tag("db.rows", jaegerTagTypeLong, func() {
+		w.Field(thriftTypeI64, 6)
+		w.I64(42)
+	})
+	tag("db.ratio", jaegerTagTypeDouble, func() {
+		w.Field(thriftTypeDouble, 4)
+		w.Double(0.25)
+	})
+	tag("db.cached", jaegerTagTypeBool, func() {
+		w.BoolField(5, true)
+	})
+
+	w.Field(thriftTypeList, 11)
+	w.List(thriftTypeStruct, 1)
+	w.StructBegin()
+	w.Field(thriftTypeI64, 1)
+	w.I64(1560276970500000)
+	w.Field(thriftTypeList, 2)
+	w.List(thriftTypeStruct, 3)
+	stringTag("event", "error")
+	stringTag("error.kind", "SQLException")
+	stringTag("message", "connection refused")
+	w.Stop()
+	w.Stop()
+
+	// second span, following from the first one
+	w.StructBegin()
+	w.Field(thriftTypeI64, 1)
+	w.I64(0xdead)
+	w.Field(thriftTypeI64, 3)
+	w.I64(0xcccc)
+	w.Field(thriftTypeI64, 4)
+	w.I64(0)
+	w.Field(thriftTypeString, 5)
+	w.String("async work")
+	w.Field(thriftTypeList, 6)
+	w.List(thriftTypeStruct, 1)
+	reference(1, 0xbeaf)
+	w.Field(thriftTypeI64, 8)
+	w.I64(1560276971000000)
+	w.Field(thriftTypeI64, 9)
+	w.I64(1000)
+	w.Stop()
+
+	// end of batch
+	w.Stop()
+
+	// end of arguments
+	w.Stop()
+
+	return w.Bytes()
+}
+
+func TestParseJaegerThriftBinary(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJaegerThriftBinary(bytes.NewReader(encodedJaegerThriftBinary()))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(Equal([]proxy.Span{{
+		Id:      0xbeaf,
+		Trace:   0xdead,
+		Parent:  0xaaaa,
+		Name:    "getconnection",
+		Service: "core-services",
+		Kind:    proxy.KindServer,
+
+		Sampling: proxy.Sampling{Decided: true, Priority: proxy.PriorityAutoKeep},
+
+		LocalEndpoint: &proxy.Endpoint{ServiceName: "core-services"},
+
+		Timestamp: proxy.Timestamp(1560276970 * time.Second),
+		Duration:  1000 * time.Millisecond,
+
+		Tags: map[string]string{
+			"lc":               "postgres",
+			"db.rows":          "42",
+			tagProtocolVersion: tagJaegerThrift,
+		},
+		Metrics: map[string]float64{"db.rows": 42},
+
+		Timings: proxy.Timings{
+			SR: proxy.Timestamp(1560276970 * time.Second),
+			SS: proxy.Timestamp(1560276971 * time.Second),
+		},
+	}}))
+}
+
+func TestParseJaegerThriftBinary_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := encodedJaegerThriftBinary()
+
+	// truncated input must not panic
+	for idx := 0; idx < len(encoded); idx++ {
+		_, err := ParseJaegerThriftBinary(bytes.NewReader(encoded[:idx]))
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+func encodedJaegerThriftBinary() []byte {
+	var w thriftBinaryWriter
+
+	tag := func(key string, tagType int32, value func()) {
+		w.Field(thriftTypeString, 1)
+		w.String(key)
+		w.Field(thriftTypeI32, 2)
+		w.I32(tagType)
+		value()
+		w.Stop()
+	}
+
+	stringTag := func(key, value string) {
+		tag(key, jaegerTagTypeString, func() {
+			w.Field(thriftTypeString, 3)
+			w.String(value)
+		})
+	}
+
+	// the process
+	w.Field(thriftTypeStruct, 1)
+	w.Field(thriftTypeString, 1)
+	w.String("core-services")
+	w.Field(thriftTypeList, 2)
+	w.List(thriftTypeStruct, 1)
+	stringTag("hostname", "localhost")
+	w.Stop()
+
+	w.Field(thriftTypeList, 2)
+	w.List(thriftTypeStruct, 1)
+
+	w.Field(thriftTypeI64, 1)
+	w.I64(0xdead)
+	w.Field(thriftTypeI64, 2)
+	w.I64(0)
+	w.Field(thriftTypeI64, 3)
+	w.I64(0xbeaf)
+	w.Field(thriftTypeI64, 4)
+	w.I64(0xaaaa)
+	w.Field(thriftTypeString, 5)
+	w.String("getconnection")
+	w.Field(thriftTypeI32, 7)
+	w.I32(1)
+	w.Field(thriftTypeI64, 8)
+	w.I64(1560276970000000)
+	w.Field(thriftTypeI64, 9)
+	w.I64(1000000)
+
+	w.Field(thriftTypeList, 10)
+	w.List(thriftTypeStruct, 3)
+	stringTag("component", "postgres")
+	stringTag("span.kind", "server")
+	tag("db.rows", jaegerTagTypeLong, func() {
+		w.Field(thriftTypeI64, 6)
+		w.I64(42)
+	})
+	w.Stop()
+
+	// end of batch
+	w.Stop()
+
+	return w.Bytes()
+}
@@ -2,34 +2,52 @@ package codec
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/flachnetz/dd-zipkin-proxy/proxy"
 	"github.com/pkg/errors"
 	"io"
-	"strings"
+	"strconv"
 	"time"
 )
 
+// bits of the flags of a jaeger span
+const (
+	jaegerFlagSampled = 1
+	jaegerFlagDebug   = 2
+)
+
 type jaegerTag struct {
 	Key   string      `json:"key"`
 	Value interface{} `json:"value"`
 }
 
+type jaegerReference struct {
+	RefType string        `json:"refType"`
+	TraceId proxy.TraceId `json:"traceID"`
+	SpanId  Id            `json:"spanID"`
+}
+
+type jaegerLog struct {
+	Timestamp uint64      `json:"timestamp"`
+	Fields    []jaegerTag `json:"fields"`
+}
+
 type jaegerSpan struct {
-	TraceId Id `json:"traceID"`
-	SpanId  Id `json:"spanID"`
+	TraceId proxy.TraceId `json:"traceID"`
+	SpanId  Id            `json:"spanID"`
 
 	OperationName string `json:"operationName"`
 
-	References []struct {
-		RefType string `json:"refType"`
-		SpanId  Id     `json:"spanID"`
-	} `json:"references"`
+	References []jaegerReference `json:"references"`
 
 	Timestamp uint64 `json:"startTime"`
 	Duration  uint64 `json:"duration"`
 
+	Flags uint32 `json:"flags"`
+
 	ProcessId string      `json:"processID"`
 	Tags      []jaegerTag `json:"tags"`
+	Logs      []jaegerLog `json:"logs"`
 }
 
 type jaegerObject struct {
@@ -40,7 +58,22 @@ type jaegerObject struct {
 }
 
 type jaegerProcess struct {
-	ServiceName string `json:"serviceName"`
+	ServiceName string      `json:"serviceName"`
+	Tags        []jaegerTag `json:"tags"`
+}
+
+// Returns the endpoint of the process, using the 'ip' tag jaeger clients add.
+func (process *jaegerProcess) Endpoint() *proxy.Endpoint {
+	var local endpoint
+	local.ServiceName = process.ServiceName
+
+	for _, tag := range process.Tags {
+		if tag.Key == "ip" {
+			local.Ipv4 = jaegerIpv4ToString(tag.Value)
+		}
+	}
+
+	return local.ToEndpoint()
 }
 
 func ParseJaeger(input io.Reader) ([]proxy.Span, error) {
@@ -55,31 +88,69 @@ func ParseJaeger(input io.Reader) ([]proxy.Span, error) {
 
 	for _, dataObject := range decoded.Data {
 		for _, span := range dataObject.Spans {
-			parsedSpans = append(parsedSpans, span.ToSpan(dataObject.Processes))
+			parsedSpans = append(parsedSpans, span.ToSpan(dataObject.Processes[span.ProcessId]))
 		}
 	}
 
 	return parsedSpans, nil
 }
 
-func (span *jaegerSpan) ToSpan(procs map[string]jaegerProcess) proxy.Span {
+func (span *jaegerSpan) ToSpan(process jaegerProcess) proxy.Span {
 	// if no CHILD_OF reference exists then this is a root span.
 	parentId := span.SpanId
 
 	for _, ref := range span.References {
-		if ref.RefType == "CHILD_OF" {
+		if ref.RefType == "CHILD_OF" && ref.SpanId != 0 && span.inTrace(ref) {
 			parentId = ref.SpanId
+			break
+		}
+	}
+
+	proxySpan := proxy.NewSpan(span.OperationName, span.TraceId.Low, span.SpanId, parentId)
+	proxySpan.TraceHigh = span.TraceId.High
+
+	// all other references are kept as links. A FOLLOWS_FROM reference
+	// might later be used as parent if the trace has no root otherwise.
+	for _, ref := range span.References {
+		if ref.SpanId == 0 || ref.SpanId == parentId && span.inTrace(ref) {
+			continue
+		}
+
+		traceId := ref.TraceId
+		if traceId.IsUnknown() {
+			traceId = span.TraceId
 		}
+
+		proxySpan.AddLink(proxy.Link{
+			Trace:       traceId,
+			Span:        ref.SpanId,
+			FollowsFrom: ref.RefType == "FOLLOWS_FROM",
+		})
 	}
 
-	proxySpan := proxy.NewSpan(span.OperationName, span.TraceId, span.SpanId, parentId)
+	proxySpan.Service = process.ServiceName
+	proxySpan.LocalEndpoint = process.Endpoint()
 
-	proxySpan.Service = procs[span.ProcessId].ServiceName
+	var remote endpoint
 
 	var spanKind string
 	for _, tag := range span.Tags {
-		value, ok := tag.Value.(string)
-		if !ok {
+		switch tag.Key {
+		case "peer.service":
+			remote.ServiceName = jaegerValueToString(tag.Value)
+			continue
+
+		case "peer.ipv4":
+			remote.Ipv4 = jaegerIpv4ToString(tag.Value)
+			continue
+
+		case "peer.ipv6":
+			remote.Ipv6 = jaegerValueToString(tag.Value)
+			continue
+
+		case "peer.port":
+			port, _ := strconv.ParseUint(jaegerValueToString(tag.Value), 10, 16)
+			remote.Port = port
 			continue
 		}
 
@@ -93,13 +164,56 @@ func (span *jaegerSpan) ToSpan(procs map[string]jaegerProcess) proxy.Span {
 		}
 
 		if key == "span.kind" {
-			spanKind = value
+			spanKind = jaegerValueToString(tag.Value)
 			continue
 		}
 
-		proxySpan.AddTag(key, value)
+		addTagValue(&proxySpan, key, jaegerTagValue(tag.Value))
 	}
 
+	for _, log := range span.Logs {
+		var event string
+		for _, field := range log.Fields {
+			if field.Key == "event" {
+				event, _ = field.Value.(string)
+			}
+		}
+
+		proxySpan.AddEvent(log.ToEvent(event))
+
+		if event != "error" {
+			continue
+		}
+
+		// map error logs to the error tags datadog understands
+		for _, field := range log.Fields {
+			value, ok := field.Value.(string)
+			if !ok {
+				continue
+			}
+
+			switch field.Key {
+			case "error.kind":
+				proxySpan.AddTag("error.type", value)
+			case "message", "error.object":
+				proxySpan.AddTag("error.msg", value)
+			case "stack":
+				proxySpan.AddTag("error.stack", value)
+			}
+		}
+	}
+
+	proxySpan.RemoteEndpoint = remote.ToEndpoint()
+
+	// jaeger clients only report sampled spans, so
+	// flags without the sampled bit are not a decision.
+	if span.Flags&jaegerFlagSampled != 0 {
+		proxySpan.Sampling.SetSampled(true)
+	}
+
+	proxySpan.Sampling.Debug = span.Flags&jaegerFlagDebug != 0
+	takeSamplingPriorityTag(&proxySpan)
+
 	proxySpan.AddTag(tagProtocolVersion, tagJaeger)
 
 	proxySpan.Timestamp = proxy.Microseconds(int64(span.Timestamp))
@@ -109,15 +223,79 @@ func (span *jaegerSpan) ToSpan(procs map[string]jaegerProcess) proxy.Span {
 		proxySpan.Duration = 1 * time.Millisecond
 	}
 
-	if strings.EqualFold(spanKind, "client") {
-		proxySpan.Timings.CS = proxySpan.Timestamp
-		proxySpan.Timings.CR = proxySpan.Timestamp.Add(proxySpan.Duration)
+	proxySpan.Kind = proxy.ParseKind(spanKind)
+	fillInTimings(&proxySpan)
+
+	return proxySpan
+}
+
+// Checks if the reference points to a span in the same trace.
+// References without a trace id are always in the same trace.
+func (span *jaegerSpan) inTrace(ref jaegerReference) bool {
+	return ref.TraceId.IsUnknown() || ref.TraceId == span.TraceId
+}
+
+// Converts the log to an event. The 'event' field is used as name of the
+// event, all other fields are kept as tags.
+func (log *jaegerLog) ToEvent(name string) proxy.Event {
+	event := proxy.Event{
+		Timestamp: proxy.Microseconds(int64(log.Timestamp)),
+		Name:      name,
 	}
 
-	if strings.EqualFold(spanKind, "server") {
-		proxySpan.Timings.SR = proxySpan.Timestamp
-		proxySpan.Timings.SS = proxySpan.Timestamp.Add(proxySpan.Duration)
+	if event.Name == "" {
+		event.Name = "log"
 	}
 
-	return proxySpan
+	for _, field := range log.Fields {
+		if field.Key == "event" && field.Value == name {
+			continue
+		}
+
+		if event.Tags == nil {
+			event.Tags = make(map[string]string, len(log.Fields))
+		}
+
+		event.Tags[field.Key] = jaegerValueToString(field.Value)
+	}
+
+	return event
+}
+
+// Jaeger clients send ipv4 addresses either as string or as integer.
+func jaegerIpv4ToString(value interface{}) string {
+	text := jaegerValueToString(value)
+
+	if ip, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return ipv4ToString(uint32(ip))
+	}
+
+	return text
+}
+
+// Numbers keep their type, all other values are formatted as string.
+func jaegerTagValue(value interface{}) tagValue {
+	switch value := value.(type) {
+	case float64:
+		return floatTagValue(value)
+
+	case int64:
+		return intTagValue(value)
+
+	default:
+		return textTagValue(jaegerValueToString(value))
+	}
+}
+
+func jaegerValueToString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+
+	default:
+		return fmt.Sprint(value)
+	}
 }
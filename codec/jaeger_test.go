@@ -22,6 +22,9 @@ func TestParseJaeger(t *testing.T) {
 		Parent:  0xaaaa,
 		Name:    "getconnection",
 		Service: "core-services",
+		Kind:    proxy.KindClient,
+
+		LocalEndpoint: &proxy.Endpoint{ServiceName: "core-services"},
 
 		// timestamp is also picked from the CS/CR if available
 		Timestamp: proxy.Timestamp(1560276970 * time.Second),
@@ -41,6 +44,56 @@ func TestParseJaeger(t *testing.T) {
 	}))
 }
 
+func TestParseJaeger_Logs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJaeger(strings.NewReader(`{"data": [{"spans": [{
+		"traceID": "dead", "spanID": "beaf", "operationName": "getconnection",
+		"logs": [
+			{"timestamp": 1560276970010000, "fields": [{"key": "event", "value": "retry"}, {"key": "attempt", "value": 2}]},
+			{"timestamp": 1560276970020000, "fields": [{"key": "message", "value": "connected"}]}
+		]
+	}]}]}`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].Events).To(Equal([]proxy.Event{
+		{
+			Timestamp: proxy.Timestamp(1560276970*time.Second + 10*time.Millisecond),
+			Name:      "retry",
+			Tags:      map[string]string{"attempt": "2"},
+		},
+		{
+			Timestamp: proxy.Timestamp(1560276970*time.Second + 20*time.Millisecond),
+			Name:      "log",
+			Tags:      map[string]string{"message": "connected"},
+		},
+	}))
+}
+
+func TestParseJaeger_References(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJaeger(strings.NewReader(`{"data": [{"spans": [{
+		"traceID": "dead", "spanID": "beaf", "operationName": "consume",
+		"references": [
+			{"refType": "FOLLOWS_FROM", "traceID": "cccc", "spanID": "bbbb"},
+			{"refType": "CHILD_OF", "traceID": "dead", "spanID": "aaaa"},
+			{"refType": "CHILD_OF", "traceID": "dead", "spanID": "abab"}
+		]
+	}]}]}`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+
+	// the first CHILD_OF reference is the parent, all others are kept as links
+	g.Expect(spans[0].Parent).To(Equal(proxy.Id(0xaaaa)))
+	g.Expect(spans[0].Links).To(Equal([]proxy.Link{
+		{Trace: proxy.TraceId{Low: 0xcccc}, Span: 0xbbbb, FollowsFrom: true},
+		{Trace: proxy.TraceId{Low: 0xdead}, Span: 0xabab},
+	}))
+}
+
 const encodedJaeger = `
 {
     "data": [
@@ -12,14 +12,15 @@ import (
 )
 
 type spanV1 struct {
-	TraceID  Id `json:"traceId"`
-	ID       Id `json:"id"`
-	ParentID Id `json:"parentId"`
+	TraceID  proxy.TraceId `json:"traceId"`
+	ID       Id            `json:"id"`
+	ParentID Id            `json:"parentId"`
 
-	Annotations       [4]annotationV1      `json:"annotations"`
+	Annotations       []annotationV1       `json:"annotations"`
 	BinaryAnnotations []binaryAnnotationV1 `json:"binaryAnnotations"`
 
-	Name string `json:"name"`
+	Name  string `json:"name"`
+	Debug bool   `json:"debug"`
 
 	Timestamp uint64 `json:"timestamp"`
 	Duration  uint64 `json:"duration"`
@@ -33,12 +34,29 @@ type annotationV1 struct {
 
 type binaryAnnotationV1 struct {
 	Key      string   `json:"key"`
-	Value    string   `json:"value"`
+	Value    tagValue `json:"value"`
 	Endpoint endpoint `json:"endpoint"`
 }
 
 type endpoint struct {
 	ServiceName string `json:"serviceName"`
+	Ipv4        string `json:"ipv4"`
+	Ipv6        string `json:"ipv6"`
+	Port        uint64 `json:"port"`
+}
+
+// Converts the endpoint. Returns nil if nothing is known about the endpoint.
+func (ep *endpoint) ToEndpoint() *proxy.Endpoint {
+	if *ep == (endpoint{}) {
+		return nil
+	}
+
+	return &proxy.Endpoint{
+		ServiceName: ep.ServiceName,
+		IPv4:        ep.Ipv4,
+		IPv6:        ep.Ipv6,
+		Port:        int(ep.Port),
+	}
 }
 
 var poolSpansV1 = sync.Pool{
@@ -79,31 +97,80 @@ func ParseJsonV1(input io.Reader) ([]proxy.Span, error) {
 }
 
 func (span *spanV1) ToSpan() proxy.Span {
-	proxySpan := proxy.NewSpan(span.Name, span.TraceID, span.ID, span.ParentID)
+	proxySpan := proxy.NewSpan(span.Name, span.TraceID.Low, span.ID, span.ParentID)
+	proxySpan.TraceHigh = span.TraceID.High
 
 	for _, annotation := range span.Annotations {
 		if annotation.Timestamp == 0 {
 			continue
 		}
 
-		proxySpan.AddTiming(annotation.Value,
-			proxy.Microseconds(int64(annotation.Timestamp)))
+		timestamp := proxy.Microseconds(int64(annotation.Timestamp))
+
+		switch annotation.Value {
+		case "cs", "cr", "sr", "ss":
+			proxySpan.AddTiming(annotation.Value, timestamp)
+
+		case "ms", "mr":
+			// only mark the kind of the span, see kindOfSpanV1
+
+		default:
+			proxySpan.AddEvent(proxy.Event{Timestamp: timestamp, Name: annotation.Value})
+		}
 
 		if proxySpan.Service == "" && annotation.Endpoint.ServiceName != "" {
 			proxySpan.Service = annotation.Endpoint.ServiceName
+			proxySpan.LocalEndpoint = annotation.Endpoint.ToEndpoint()
 		}
 	}
 
+	proxySpan.Kind = kindOfSpanV1(&proxySpan, span.Annotations)
+
 	proxySpan.Tags = make(map[string]string, 1+len(span.BinaryAnnotations))
 
-	for _, annotation := range span.BinaryAnnotations {
-		proxySpan.AddTag(annotation.Key, annotation.Value)
+	// the addresses of the other side of the span
+	var serverAddress, clientAddress, messageAddress *proxy.Endpoint
+
+	for idx := range span.BinaryAnnotations {
+		annotation := &span.BinaryAnnotations[idx]
+
+		switch annotation.Key {
+		case "sa":
+			serverAddress = annotation.Endpoint.ToEndpoint()
+			continue
+
+		case "ca":
+			clientAddress = annotation.Endpoint.ToEndpoint()
+			continue
+
+		case "ma":
+			messageAddress = annotation.Endpoint.ToEndpoint()
+			continue
+		}
+
+		addTagValue(&proxySpan, annotation.Key, annotation.Value)
 
 		if proxySpan.Service == "" && annotation.Endpoint.ServiceName != "" {
 			proxySpan.Service = annotation.Endpoint.ServiceName
+			proxySpan.LocalEndpoint = annotation.Endpoint.ToEndpoint()
 		}
 	}
 
+	switch proxySpan.Kind {
+	case proxy.KindClient, proxy.KindUnspecified:
+		proxySpan.RemoteEndpoint = serverAddress
+
+	case proxy.KindServer:
+		proxySpan.RemoteEndpoint = clientAddress
+
+	case proxy.KindProducer, proxy.KindConsumer:
+		proxySpan.RemoteEndpoint = messageAddress
+	}
+
+	proxySpan.Sampling.Debug = span.Debug
+	takeB3SampledTag(&proxySpan)
+	takeSamplingPriorityTag(&proxySpan)
+
 	proxySpan.AddTag(tagProtocolVersion, tagJsonV1)
 
 	if span.Timestamp != 0 {
@@ -119,6 +186,46 @@ func (span *spanV1) ToSpan() proxy.Span {
 	return proxySpan
 }
 
+// Derives the kind of a v1 span from its annotations. A span that contains the
+// annotations of both, client and server, is treated as server span.
+func kindOfSpanV1(proxySpan *proxy.Span, annotations []annotationV1) proxy.Kind {
+	timings := proxySpan.Timings
+
+	switch {
+	case timings.SR.IsValid() || timings.SS.IsValid():
+		return proxy.KindServer
+
+	case timings.CS.IsValid() || timings.CR.IsValid():
+		return proxy.KindClient
+	}
+
+	for _, annotation := range annotations {
+		switch annotation.Value {
+		case "ms":
+			return proxy.KindProducer
+
+		case "mr":
+			return proxy.KindConsumer
+		}
+	}
+
+	return proxy.KindUnspecified
+}
+
+// Fills in the timings of client and server spans from their timestamp and duration,
+// for formats that only transport the kind of the span.
+func fillInTimings(proxySpan *proxy.Span) {
+	switch proxySpan.Kind {
+	case proxy.KindClient:
+		proxySpan.Timings.CS = proxySpan.Timestamp
+		proxySpan.Timings.CR = proxySpan.Timestamp.Add(proxySpan.Duration)
+
+	case proxy.KindServer:
+		proxySpan.Timings.SR = proxySpan.Timestamp
+		proxySpan.Timings.SS = proxySpan.Timestamp.Add(proxySpan.Duration)
+	}
+}
+
 func fillInTimestamp(proxySpan *proxy.Span) {
 	sr := proxySpan.Timings.SR
 	ss := proxySpan.Timings.SS
@@ -149,7 +256,7 @@ func spanV1ValueDecoder() hyperjson.ValueDecoder {
 		{
 			JsonName: "traceId",
 			Offset:   hyperjson.OffsetOf(spanV1{}, "TraceID"),
-			Decoder:  idValueDecoder,
+			Decoder:  traceIdValueDecoder,
 		},
 		{
 			JsonName: "parentId",
@@ -171,6 +278,11 @@ func spanV1ValueDecoder() hyperjson.ValueDecoder {
 			Offset:   hyperjson.OffsetOf(spanV1{}, "Name"),
 			Decoder:  hyperjson.StringValueDecoder,
 		},
+		{
+			JsonName: "debug",
+			Offset:   hyperjson.OffsetOf(spanV1{}, "Debug"),
+			Decoder:  hyperjson.BoolValueDecoder,
+		},
 		{
 			JsonName: "binaryAnnotations",
 			Offset:   hyperjson.OffsetOf(spanV1{}, "BinaryAnnotations"),
@@ -185,7 +297,7 @@ func spanV1ValueDecoder() hyperjson.ValueDecoder {
 					{
 						JsonName: "value",
 						Offset:   hyperjson.OffsetOf(binaryAnnotationV1{}, "Value"),
-						Decoder:  anyValueDecoder,
+						Decoder:  tagValueDecoder,
 					},
 					{
 						JsonName: "endpoint",
@@ -197,8 +309,8 @@ func spanV1ValueDecoder() hyperjson.ValueDecoder {
 		{
 			JsonName: "annotations",
 			Offset:   hyperjson.OffsetOf(spanV1{}, "Annotations"),
-			Decoder: hyperjson.MakeArrayDecoder(
-				reflect2.TypeOf([4]annotationV1{}).(reflect2.ArrayType),
+			Decoder: hyperjson.MakeSliceDecoder(
+				reflect2.TypeOf([]annotationV1{}).(reflect2.SliceType),
 				hyperjson.MakeStructDecoder([]hyperjson.Field{
 					{
 						JsonName: "timestamp",
@@ -222,14 +334,19 @@ func spanV1ValueDecoder() hyperjson.ValueDecoder {
 	return func(target unsafe.Pointer, p *hyperjson.Parser) error {
 		span := (*spanV1)(target)
 
-		span.TraceID = 0
+		span.TraceID = proxy.TraceId{}
 		span.ID = 0
 		span.ParentID = 0
 		span.Duration = 0
 		span.Timestamp = 0
 		span.Name = ""
+		span.Debug = false
+
+		for idx := range span.Annotations {
+			span.Annotations[idx] = annotationV1{}
+		}
 
-		span.Annotations = [4]annotationV1{}
+		span.Annotations = span.Annotations[:0]
 
 		for idx := range span.BinaryAnnotations {
 			span.BinaryAnnotations[idx] = binaryAnnotationV1{}
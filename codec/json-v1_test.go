@@ -24,6 +24,10 @@ func TestParseJsonV1(t *testing.T) {
 		Parent:  0xbeaf,
 		Name:    "span name",
 		Service: "my-service",
+		Kind:    proxy.KindClient,
+
+		LocalEndpoint:  &proxy.Endpoint{ServiceName: "my-service"},
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "postgres", IPv4: "10.0.0.2", Port: 5432},
 
 		// timestamp is also picked from the CS/CR if available
 		Timestamp: proxy.Timestamp(1560276970 * time.Second),
@@ -36,6 +40,7 @@ func TestParseJsonV1(t *testing.T) {
 			"http.status":      "404",
 			tagProtocolVersion: tagJsonV1,
 		},
+		Metrics: map[string]float64{"http.status": 404},
 
 		Timings: proxy.Timings{
 			CS: proxy.Timestamp(1560276970 * time.Second),
@@ -44,6 +49,55 @@ func TestParseJsonV1(t *testing.T) {
 	}))
 }
 
+func TestParseJsonV1_Kind(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV1(strings.NewReader(`[
+		{"traceId": "beaf", "id": "1", "annotations": [{"timestamp": 1560276970000000, "value": "ms"}]},
+		{"traceId": "beaf", "id": "2", "annotations": [{"timestamp": 1560276970000000, "value": "mr"}]},
+		{"traceId": "beaf", "id": "3", "annotations": [
+			{"timestamp": 1560276970000000, "value": "cs"},
+			{"timestamp": 1560276970000000, "value": "sr"}
+		]},
+		{"traceId": "beaf", "id": "4"}
+	]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(4))
+
+	g.Expect(spans[0].Kind).To(Equal(proxy.KindProducer))
+	g.Expect(spans[1].Kind).To(Equal(proxy.KindConsumer))
+	g.Expect(spans[2].Kind).To(Equal(proxy.KindServer))
+	g.Expect(spans[3].Kind).To(Equal(proxy.KindUnspecified))
+}
+
+func TestParseJsonV1_Events(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV1(strings.NewReader(`[{
+		"traceId": "beaf", "id": "dead",
+		"annotations": [
+			{"timestamp": 1560276970000000, "value": "cs"},
+			{"timestamp": 1560276970010000, "value": "ws"},
+			{"timestamp": 1560276970020000, "value": "wr"},
+			{"timestamp": 1560276970030000, "value": "exception"},
+			{"timestamp": 1560276971000000, "value": "cr"}
+		]
+	}]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+
+	g.Expect(spans[0].Timings.CS).To(Equal(proxy.Timestamp(1560276970 * time.Second)))
+	g.Expect(spans[0].Timings.CR).To(Equal(proxy.Timestamp(1560276971 * time.Second)))
+
+	g.Expect(spans[0].Events).To(Equal([]proxy.Event{
+		{Timestamp: proxy.Timestamp(1560276970*time.Second + 10*time.Millisecond), Name: "ws"},
+		{Timestamp: proxy.Timestamp(1560276970*time.Second + 20*time.Millisecond), Name: "wr"},
+		{Timestamp: proxy.Timestamp(1560276970*time.Second + 30*time.Millisecond), Name: "exception"},
+	}))
+}
+
 func jsonCompact(input []byte) []byte {
 	var buf bytes.Buffer
 	_ = json.Compact(&buf, input)
@@ -102,6 +156,15 @@ const encodedJsonV1 = `[
 					"endpoint": {
 						"serviceName": "my-service"
 					}
+				},
+				{
+					"key": "sa",
+					"value": true,
+					"endpoint": {
+						"serviceName": "postgres",
+						"ipv4": "10.0.0.2",
+						"port": 5432
+					}
 				}
 			]
 		}
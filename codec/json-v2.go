@@ -6,28 +6,37 @@ import (
 	"github.com/modern-go/reflect2"
 	"github.com/pkg/errors"
 	"io"
-	"strings"
 	"time"
 	"unsafe"
 )
 
 type spanV2 struct {
-	TraceID  Id `json:"traceId"`
-	ID       Id `json:"id"`
-	ParentID Id `json:"parentId"`
+	TraceID  proxy.TraceId `json:"traceId"`
+	ID       Id            `json:"id"`
+	ParentID Id            `json:"parentId"`
 
 	Name string `json:"name"`
 
-	Endpoint endpoint `json:"localEndpoint"`
+	Endpoint       endpoint `json:"localEndpoint"`
+	RemoteEndpoint endpoint `json:"remoteEndpoint"`
 
-	Tags map[string]string `json:"tags"`
+	Tags map[string]tagValue `json:"tags"`
 
-	Kind string `json:"kind"`
+	Annotations []annotationV2 `json:"annotations"`
+
+	Kind   string `json:"kind"`
+	Debug  bool   `json:"debug"`
+	Shared bool   `json:"shared"`
 
 	Timestamp uint64 `json:"timestamp"`
 	Duration  uint64 `json:"duration"`
 }
 
+type annotationV2 struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
 func ParseJsonV2(input io.Reader) ([]proxy.Span, error) {
 	// get a buffer to re-use
 	buf := bufferPool.Get()
@@ -56,15 +65,35 @@ func ParseJsonV2(input io.Reader) ([]proxy.Span, error) {
 }
 
 func (span *spanV2) ToSpan() proxy.Span {
-	proxySpan := proxy.NewSpan(span.Name, span.TraceID, span.ID, span.ParentID)
+	proxySpan := proxy.NewSpan(span.Name, span.TraceID.Low, span.ID, span.ParentID)
+	proxySpan.TraceHigh = span.TraceID.High
 
 	if span.Endpoint.ServiceName != "" {
 		proxySpan.Service = span.Endpoint.ServiceName
 	}
 
-	proxySpan.Tags = span.Tags
+	proxySpan.LocalEndpoint = span.Endpoint.ToEndpoint()
+	proxySpan.RemoteEndpoint = span.RemoteEndpoint.ToEndpoint()
+
+	proxySpan.Tags = make(map[string]string, 1+len(span.Tags))
+	for key, value := range span.Tags {
+		addTagValue(&proxySpan, key, value)
+	}
+
+	proxySpan.Shared = span.Shared
+	proxySpan.Sampling.Debug = span.Debug
+	takeB3SampledTag(&proxySpan)
+	takeSamplingPriorityTag(&proxySpan)
+
 	proxySpan.AddTag(tagProtocolVersion, tagJsonV2)
 
+	for _, annotation := range span.Annotations {
+		proxySpan.AddEvent(proxy.Event{
+			Timestamp: proxy.Microseconds(int64(annotation.Timestamp)),
+			Name:      annotation.Value,
+		})
+	}
+
 	proxySpan.Timestamp = proxy.Microseconds(int64(span.Timestamp))
 	proxySpan.Duration = time.Duration(span.Duration) * time.Microsecond
 
@@ -72,15 +101,8 @@ func (span *spanV2) ToSpan() proxy.Span {
 		proxySpan.Duration = 1 * time.Millisecond
 	}
 
-	if strings.EqualFold(span.Kind, "client") {
-		proxySpan.Timings.CS = proxySpan.Timestamp
-		proxySpan.Timings.CR = proxySpan.Timestamp.Add(proxySpan.Duration)
-	}
-
-	if strings.EqualFold(span.Kind, "server") {
-		proxySpan.Timings.SR = proxySpan.Timestamp
-		proxySpan.Timings.SS = proxySpan.Timestamp.Add(proxySpan.Duration)
-	}
+	proxySpan.Kind = proxy.ParseKind(span.Kind)
+	fillInTimings(&proxySpan)
 
 	return proxySpan
 }
@@ -111,6 +133,32 @@ func idValueDecoder(target unsafe.Pointer, p *hyperjson.Parser) error {
 	return nil
 }
 
+func traceIdValueDecoder(target unsafe.Pointer, p *hyperjson.Parser) error {
+	next, err := p.NextType()
+	if err != nil {
+		return err
+	}
+
+	if next == hyperjson.TypeNull {
+		*(*proxy.TraceId)(target) = proxy.TraceId{}
+		return p.Skip()
+	}
+
+	tok, err := p.ReadString()
+	if err != nil {
+		return errors.WithMessage(err, "decode trace id value")
+	}
+
+	result, err := proxy.ParseTraceId(tok.Value)
+	if err != nil {
+		return err
+	}
+
+	*(*proxy.TraceId)(target) = result
+
+	return nil
+}
+
 func spanV2ValueDecoder() hyperjson.ValueDecoder {
 	decoder := hyperjson.MakeStructDecoder([]hyperjson.Field{
 		{
@@ -121,7 +169,7 @@ func spanV2ValueDecoder() hyperjson.ValueDecoder {
 		{
 			JsonName: "traceId",
 			Offset:   hyperjson.OffsetOf(spanV2{}, "TraceID"),
-			Decoder:  idValueDecoder,
+			Decoder:  traceIdValueDecoder,
 		},
 		{
 			JsonName: "parentId",
@@ -146,18 +194,51 @@ func spanV2ValueDecoder() hyperjson.ValueDecoder {
 		{
 			JsonName: "tags",
 			Offset:   hyperjson.OffsetOf(spanV2{}, "Tags"),
-			Decoder:  hyperjson.MakeMapDecoder(hyperjson.StringValueDecoder, hyperjson.StringValueDecoder),
+			Decoder:  tagsValueDecoder,
+		},
+		{
+			JsonName: "annotations",
+			Offset:   hyperjson.OffsetOf(spanV2{}, "Annotations"),
+			Decoder: hyperjson.MakeSliceDecoder(
+				reflect2.TypeOf([]annotationV2{}).(reflect2.SliceType),
+				hyperjson.MakeStructDecoder([]hyperjson.Field{
+					{
+						JsonName: "timestamp",
+						Offset:   hyperjson.OffsetOf(annotationV2{}, "Timestamp"),
+						Decoder:  hyperjson.Uint64ValueDecoder,
+					},
+					{
+						JsonName: "value",
+						Offset:   hyperjson.OffsetOf(annotationV2{}, "Value"),
+						Decoder:  hyperjson.StringValueDecoder,
+					},
+				})),
 		},
 		{
 			JsonName: "kind",
 			Offset:   hyperjson.OffsetOf(spanV2{}, "Kind"),
 			Decoder:  hyperjson.StringValueDecoder,
 		},
+		{
+			JsonName: "debug",
+			Offset:   hyperjson.OffsetOf(spanV2{}, "Debug"),
+			Decoder:  hyperjson.BoolValueDecoder,
+		},
+		{
+			JsonName: "shared",
+			Offset:   hyperjson.OffsetOf(spanV2{}, "Shared"),
+			Decoder:  hyperjson.BoolValueDecoder,
+		},
 		{
 			JsonName: "localEndpoint",
 			Offset:   hyperjson.OffsetOf(spanV2{}, "Endpoint"),
 			Decoder:  endpointValueDecoder,
 		},
+		{
+			JsonName: "remoteEndpoint",
+			Offset:   hyperjson.OffsetOf(spanV2{}, "RemoteEndpoint"),
+			Decoder:  endpointValueDecoder,
+		},
 	})
 
 	return func(target unsafe.Pointer, p *hyperjson.Parser) error {
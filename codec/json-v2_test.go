@@ -23,6 +23,10 @@ func TestParseJsonV2(t *testing.T) {
 		Parent:  0xbeaf,
 		Name:    "span name",
 		Service: "my-service",
+		Kind:    proxy.KindClient,
+
+		LocalEndpoint:  &proxy.Endpoint{ServiceName: "my-service"},
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "postgres", IPv6: "2001:db8::2", Port: 5432},
 
 		Timestamp: proxy.Timestamp(1560276970 * time.Second),
 		Duration:  50 * time.Millisecond,
@@ -40,6 +44,133 @@ func TestParseJsonV2(t *testing.T) {
 	}))
 }
 
+func TestParseJsonV2_TraceId128(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[
+		{"traceId": "463ac35c9f6413ad48485a3953bb6124", "id": "dead"},
+		{"traceId": "48485a3953bb6124", "id": "beaf"}
+	]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(2))
+
+	g.Expect(spans[0].TraceHigh).To(Equal(proxy.Id(0x463ac35c9f6413ad)))
+	g.Expect(spans[0].Trace).To(Equal(proxy.Id(0x48485a3953bb6124)))
+	g.Expect(spans[0].TraceId().String()).To(Equal("463ac35c9f6413ad48485a3953bb6124"))
+
+	g.Expect(spans[1].TraceHigh).To(BeZero())
+	g.Expect(spans[1].Trace).To(Equal(proxy.Id(0x48485a3953bb6124)))
+
+	_, err = ParseJsonV2(strings.NewReader(`[{"traceId": "00463ac35c9f6413ad48485a3953bb6124", "id": "dead"}]`))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseJsonV2_Kind(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[
+		{"traceId": "beaf", "id": "1", "kind": "PRODUCER"},
+		{"traceId": "beaf", "id": "2", "kind": "CONSUMER"},
+		{"traceId": "beaf", "id": "3"}
+	]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(3))
+
+	g.Expect(spans[0].Kind).To(Equal(proxy.KindProducer))
+	g.Expect(spans[1].Kind).To(Equal(proxy.KindConsumer))
+	g.Expect(spans[2].Kind).To(Equal(proxy.KindUnspecified))
+
+	// producer and consumer spans have no client or server timings
+	g.Expect(spans[0].Timings).To(BeZero())
+}
+
+func TestParseJsonV2_Annotations(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[{
+		"traceId": "beaf", "id": "dead",
+		"annotations": [{"timestamp": 1560276970010000, "value": "ws"}, {"timestamp": 1560276970020000, "value": "wr"}]
+	}]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].Events).To(Equal([]proxy.Event{
+		{Timestamp: proxy.Timestamp(1560276970*time.Second + 10*time.Millisecond), Name: "ws"},
+		{Timestamp: proxy.Timestamp(1560276970*time.Second + 20*time.Millisecond), Name: "wr"},
+	}))
+}
+
+func TestParseJsonV2_TypedTags(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[{
+		"traceId": "beaf", "id": "1",
+		"tags": {
+			"http.path": "/my/path", "http.status": 404, "ratio": -1.5E+2,
+			"retry": true, "user.id": 9007199254740993, "empty": null
+		}
+	}]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+
+	// numbers are kept as sent in the tags
+	g.Expect(spans[0].Tags).To(Equal(map[string]string{
+		"http.path":        "/my/path",
+		"http.status":      "404",
+		"ratio":            "-1.5E+2",
+		"retry":            "true",
+		"user.id":          "9007199254740993",
+		"empty":            "",
+		tagProtocolVersion: tagJsonV2,
+	}))
+
+	g.Expect(spans[0].Metrics).To(Equal(map[string]float64{
+		"http.status": 404,
+		"ratio":       -150,
+	}))
+}
+
+func TestParseJsonV2_Sampling(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[
+		{"traceId": "beaf", "id": "1", "debug": true},
+		{"traceId": "beaf", "id": "2", "tags": {"sampling.priority": 0}},
+		{"traceId": "beaf", "id": "3", "tags": {"sampled": "0"}},
+		{"traceId": "beaf", "id": "4", "debug": false}
+	]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(4))
+
+	g.Expect(spans[0].Sampling).To(Equal(proxy.Sampling{Debug: true}))
+	g.Expect(spans[1].Sampling).To(Equal(proxy.Sampling{Decided: true, Priority: proxy.PriorityUserReject}))
+	g.Expect(spans[2].Sampling).To(Equal(proxy.Sampling{Decided: true, Priority: proxy.PriorityAutoReject}))
+	g.Expect(spans[3].Sampling).To(BeZero())
+
+	// the tags are consumed
+	g.Expect(spans[1].Metrics).ToNot(HaveKey("sampling.priority"))
+	g.Expect(spans[2].Tags).ToNot(HaveKey("sampled"))
+}
+
+func TestParseJsonV2_Shared(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseJsonV2(strings.NewReader(`[
+		{"traceId": "beaf", "id": "1", "kind": "CLIENT"},
+		{"traceId": "beaf", "id": "1", "kind": "SERVER", "shared": true}
+	]`))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(2))
+
+	g.Expect(spans[0].Shared).To(BeFalse())
+	g.Expect(spans[1].Shared).To(BeTrue())
+}
+
 func BenchmarkParseJsonV2(b *testing.B) {
 	data := jsonCompact([]byte(encodedJsonV2))
 
@@ -66,6 +197,12 @@ const encodedJsonV2 = `[
 				"serviceName": "my-service"
 			},
 
+			"remoteEndpoint": {
+				"serviceName": "postgres",
+				"ipv6": "2001:db8::2",
+				"port": 5432
+			},
+
 			"tags": {
 				"http.path": "/my/path",
 				"http.status": "404"
@@ -22,17 +22,78 @@ var endpointValueDecoder = hyperjson.MakeStructDecoder([]hyperjson.Field{
 		Decoder:  hyperjson.StringValueDecoder,
 		Offset:   hyperjson.OffsetOf(endpoint{}, "ServiceName"),
 	},
+	{
+		JsonName: "ipv4",
+		Decoder:  hyperjson.StringValueDecoder,
+		Offset:   hyperjson.OffsetOf(endpoint{}, "Ipv4"),
+	},
+	{
+		JsonName: "ipv6",
+		Decoder:  hyperjson.StringValueDecoder,
+		Offset:   hyperjson.OffsetOf(endpoint{}, "Ipv6"),
+	},
+	{
+		JsonName: "port",
+		Decoder:  hyperjson.Uint64ValueDecoder,
+		Offset:   hyperjson.OffsetOf(endpoint{}, "Port"),
+	},
 })
 
-// Decodes any type of literal to a string
-func anyValueDecoder(target unsafe.Pointer, p *hyperjson.Parser) error {
+// Decodes any type of literal to a tagValue. Numbers keep their type,
+// all other literals are decoded to a string.
+func tagValueDecoder(target unsafe.Pointer, p *hyperjson.Parser) error {
 	tok, err := p.ReadLiteral()
 	if err != nil {
 		return errors.WithMessage(err, "decode value")
 	}
 
-	// assign to target
-	*(*string)(target) = cache.StringForByteSliceCopy(tok.Value)
+	switch tok.Type {
+	case hyperjson.TypeNumber:
+		*(*tagValue)(target) = parseNumberTagValue(string(tok.Value))
+
+	case hyperjson.TypeNull:
+		*(*tagValue)(target) = tagValue{}
+
+	default:
+		*(*tagValue)(target) = textTagValue(cache.StringForByteSliceCopy(tok.Value))
+	}
 
 	return nil
 }
+
+// Decodes a json object of tags. Other than hyperjson.MakeMapDecoder,
+// this accepts numbers and booleans as values.
+func tagsValueDecoder(target unsafe.Pointer, p *hyperjson.Parser) error {
+	if err := p.ConsumeObjectBegin(); err != nil {
+		return errors.WithMessage(err, "begin tags")
+	}
+
+	var result map[string]tagValue
+	for {
+		next, err := p.NextType()
+		if err != nil {
+			return err
+		}
+
+		if next == hyperjson.TypeObjectEnd {
+			*(*map[string]tagValue)(target) = result
+			return p.ConsumeObjectEnd()
+		}
+
+		var key string
+		if err := hyperjson.StringValueDecoder(unsafe.Pointer(&key), p); err != nil {
+			return err
+		}
+
+		var value tagValue
+		if err := tagValueDecoder(unsafe.Pointer(&value), p); err != nil {
+			return err
+		}
+
+		if result == nil {
+			result = make(map[string]tagValue)
+		}
+
+		result[key] = value
+	}
+}
@@ -0,0 +1,329 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+)
+
+// the json encoding allows enums to be encoded by name too
+var otlpEnumValues = map[string]int64{
+	"SPAN_KIND_UNSPECIFIED": 0,
+	"SPAN_KIND_INTERNAL":    1,
+	"SPAN_KIND_SERVER":      otlpSpanKindServer,
+	"SPAN_KIND_CLIENT":      otlpSpanKindClient,
+	"SPAN_KIND_PRODUCER":    otlpSpanKindProducer,
+	"SPAN_KIND_CONSUMER":    otlpSpanKindConsumer,
+
+	"STATUS_CODE_UNSET": 0,
+	"STATUS_CODE_OK":    1,
+	"STATUS_CODE_ERROR": otlpStatusCodeError,
+}
+
+type otlpJsonRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpJsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+
+		ScopeSpans                  []otlpJsonScopeSpans `json:"scopeSpans"`
+		InstrumentationLibrarySpans []otlpJsonScopeSpans `json:"instrumentationLibrarySpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpJsonScope struct {
+	Name string `json:"name"`
+}
+
+type otlpJsonScopeSpans struct {
+	Scope                  otlpJsonScope `json:"scope"`
+	InstrumentationLibrary otlpJsonScope `json:"instrumentationLibrary"`
+
+	Spans []otlpJsonSpan `json:"spans"`
+}
+
+type otlpJsonSpan struct {
+	TraceId      proxy.TraceId `json:"traceId"`
+	SpanId       otlpJsonId    `json:"spanId"`
+	ParentSpanId otlpJsonId    `json:"parentSpanId"`
+
+	Name string       `json:"name"`
+	Kind otlpJsonEnum `json:"kind"`
+
+	StartTimeUnixNano otlpJsonInt64 `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpJsonInt64 `json:"endTimeUnixNano"`
+
+	Attributes []otlpJsonKeyValue `json:"attributes"`
+
+	Events []struct {
+		TimeUnixNano otlpJsonInt64      `json:"timeUnixNano"`
+		Name         string             `json:"name"`
+		Attributes   []otlpJsonKeyValue `json:"attributes"`
+	} `json:"events"`
+
+	Links []struct {
+		TraceId    proxy.TraceId      `json:"traceId"`
+		SpanId     otlpJsonId         `json:"spanId"`
+		Attributes []otlpJsonKeyValue `json:"attributes"`
+	} `json:"links"`
+
+	Flags otlpJsonInt64 `json:"flags"`
+
+	Status struct {
+		Code    otlpJsonEnum `json:"code"`
+		Message string       `json:"message"`
+	} `json:"status"`
+}
+
+type otlpJsonKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJsonAnyValue `json:"value"`
+}
+
+type otlpJsonAnyValue struct {
+	Value interface{}
+}
+
+// Parses an opentelemetry ExportTraceServiceRequest encoded as json.
+func ParseOtlpJson(input io.Reader) ([]proxy.Span, error) {
+	var decoded otlpJsonRequest
+	if err := json.NewDecoder(input).Decode(&decoded); err != nil {
+		return nil, errors.WithMessage(err, "parse spans for otlp json")
+	}
+
+	var parsedSpans []proxy.Span
+
+	for _, resourceSpans := range decoded.ResourceSpans {
+		resource := otlpJsonAttributes(resourceSpans.Resource.Attributes)
+
+		scopeSpans := append(resourceSpans.ScopeSpans, resourceSpans.InstrumentationLibrarySpans...)
+		for _, scopeSpan := range scopeSpans {
+			scope := scopeSpan.Scope.Name
+			if scope == "" {
+				scope = scopeSpan.InstrumentationLibrary.Name
+			}
+
+			for _, span := range scopeSpan.Spans {
+				otlpSpan := span.toOtlpSpan()
+				parsedSpans = append(parsedSpans, otlpSpan.ToSpan(resource, scope))
+			}
+		}
+	}
+
+	return parsedSpans, nil
+}
+
+func (span *otlpJsonSpan) toOtlpSpan() otlpSpan {
+	result := otlpSpan{
+		TraceID:  span.TraceId,
+		ID:       Id(span.SpanId),
+		ParentID: Id(span.ParentSpanId),
+
+		Name: span.Name,
+		Kind: int64(span.Kind),
+
+		Start: uint64(span.StartTimeUnixNano),
+		End:   uint64(span.EndTimeUnixNano),
+
+		Attributes: otlpJsonAttributes(span.Attributes),
+
+		StatusCode:    int64(span.Status.Code),
+		StatusMessage: span.Status.Message,
+
+		Flags: uint32(span.Flags),
+	}
+
+	for _, event := range span.Events {
+		result.Events = append(result.Events, otlpEvent{
+			Timestamp:  uint64(event.TimeUnixNano),
+			Name:       event.Name,
+			Attributes: otlpJsonAttributes(event.Attributes),
+		})
+	}
+
+	for _, link := range span.Links {
+		result.Links = append(result.Links, otlpLink{
+			TraceID:    link.TraceId,
+			SpanID:     Id(link.SpanId),
+			Attributes: otlpJsonAttributes(link.Attributes),
+		})
+	}
+
+	return result
+}
+
+func otlpJsonAttributes(keyValues []otlpJsonKeyValue) otlpAttributes {
+	if len(keyValues) == 0 {
+		return nil
+	}
+
+	attributes := make(otlpAttributes, len(keyValues))
+	for _, keyValue := range keyValues {
+		attributes[keyValue.Key] = keyValue.Value.Value
+	}
+
+	return attributes
+}
+
+func (value *otlpJsonAnyValue) UnmarshalJSON(encoded []byte) error {
+	decoded, err := decodeOtlpJsonAnyValue(encoded, 0)
+	if err != nil {
+		return err
+	}
+
+	value.Value = decoded
+	return nil
+}
+
+// Decodes an AnyValue. Nested values are kept raw and decoded with an increased depth,
+// so deeply nested arrays and key value lists are rejected before they exhaust the stack.
+func decodeOtlpJsonAnyValue(encoded []byte, depth int) (interface{}, error) {
+	if depth > otlpMaxValueDepth {
+		return nil, errors.New("otlp attribute value nested too deep")
+	}
+
+	var decoded struct {
+		StringValue *string        `json:"stringValue"`
+		BoolValue   *bool          `json:"boolValue"`
+		IntValue    *otlpJsonInt64 `json:"intValue"`
+		DoubleValue *float64       `json:"doubleValue"`
+		BytesValue  []byte         `json:"bytesValue"`
+
+		ArrayValue *struct {
+			Values []json.RawMessage `json:"values"`
+		} `json:"arrayValue"`
+
+		KvlistValue *struct {
+			Values []struct {
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			} `json:"values"`
+		} `json:"kvlistValue"`
+	}
+
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case decoded.StringValue != nil:
+		return *decoded.StringValue, nil
+
+	case decoded.BoolValue != nil:
+		return *decoded.BoolValue, nil
+
+	case decoded.IntValue != nil:
+		return int64(*decoded.IntValue), nil
+
+	case decoded.DoubleValue != nil:
+		return *decoded.DoubleValue, nil
+
+	case decoded.BytesValue != nil:
+		return decoded.BytesValue, nil
+
+	case decoded.ArrayValue != nil:
+		values := []interface{}{}
+		for _, element := range decoded.ArrayValue.Values {
+			value, err := decodeOtlpJsonAnyValue(element, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+
+	case decoded.KvlistValue != nil:
+		var values map[string]interface{}
+		if len(decoded.KvlistValue.Values) > 0 {
+			values = make(map[string]interface{}, len(decoded.KvlistValue.Values))
+		}
+
+		for _, keyValue := range decoded.KvlistValue.Values {
+			if len(keyValue.Value) == 0 {
+				values[keyValue.Key] = nil
+				continue
+			}
+
+			value, err := decodeOtlpJsonAnyValue(keyValue.Value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			values[keyValue.Key] = value
+		}
+
+		return values, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// A span id encoded as hex string. For ids longer than 64 bits only the lower 64 bits are kept.
+type otlpJsonId Id
+
+func (id *otlpJsonId) UnmarshalJSON(encoded []byte) error {
+	var value string
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return errors.WithMessage(err, "decode id")
+	}
+
+	if len(value) > 16 {
+		value = value[len(value)-16:]
+	}
+
+	parsed, err := proxy.ParseId([]byte(value))
+	if err != nil {
+		return err
+	}
+
+	*id = otlpJsonId(parsed)
+	return nil
+}
+
+// 64 bit integer values are encoded as strings, but we also accept plain numbers.
+type otlpJsonInt64 int64
+
+func (value *otlpJsonInt64) UnmarshalJSON(encoded []byte) error {
+	if string(encoded) == "null" {
+		return nil
+	}
+
+	encoded = bytes.Trim(encoded, `"`)
+
+	parsed, err := strconv.ParseInt(string(encoded), 10, 64)
+	if err != nil {
+		return errors.WithMessage(err, "decode integer")
+	}
+
+	*value = otlpJsonInt64(parsed)
+	return nil
+}
+
+// Enum values might be encoded as integer or by name.
+type otlpJsonEnum int64
+
+func (value *otlpJsonEnum) UnmarshalJSON(encoded []byte) error {
+	if len(encoded) > 0 && encoded[0] == '"' {
+		var name string
+		if err := json.Unmarshal(encoded, &name); err != nil {
+			return err
+		}
+
+		*value = otlpJsonEnum(otlpEnumValues[name])
+		return nil
+	}
+
+	var number int64
+	if err := json.Unmarshal(encoded, &number); err != nil {
+		return errors.WithMessage(err, "decode enum")
+	}
+
+	*value = otlpJsonEnum(number)
+	return nil
+}
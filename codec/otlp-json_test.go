@@ -0,0 +1,91 @@
+package codec
+
+import (
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+)
+
+func TestParseOtlpJson(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseOtlpJson(strings.NewReader(encodedOtlpJson))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0]).To(Equal(expectedOtlpSpan(tagOtlp)))
+}
+
+func TestParseOtlpJson_NestedTooDeep(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	nestedRequest := func(depth int) string {
+		value := `{"stringValue": "leaf"}`
+		for idx := 0; idx < depth; idx++ {
+			value = `{"arrayValue": {"values": [` + value + `]}}`
+		}
+
+		return `{"resourceSpans": [{"scopeSpans": [{"spans": [{"attributes": [{"key": "nested", "value": ` + value + `}]}]}]}]}`
+	}
+
+	_, err := ParseOtlpJson(strings.NewReader(nestedRequest(otlpMaxValueDepth)))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = ParseOtlpJson(strings.NewReader(nestedRequest(otlpMaxValueDepth + 1)))
+	g.Expect(err).To(HaveOccurred())
+}
+
+const encodedOtlpJson = `{
+	"resourceSpans": [
+		{
+			"resource": {
+				"attributes": [
+					{"key": "service.name", "value": {"stringValue": "my-service"}},
+					{"key": "deployment.environment", "value": {"stringValue": "prod"}}
+				]
+			},
+			"scopeSpans": [
+				{
+					"scope": {"name": "my-library"},
+					"spans": [
+						{
+							"traceId": "0000000000000001000000000000BEAF",
+							"spanId": "000000000000dead",
+							"parentSpanId": "000000000000aaaa",
+							"name": "GET /my/path",
+							"kind": "SPAN_KIND_SERVER",
+							"startTimeUnixNano": "1560276970000000000",
+							"endTimeUnixNano": 1560276970050000000,
+							"attributes": [
+								{"key": "http.path", "value": {"stringValue": "/my/path"}},
+								{"key": "http.status", "value": {"intValue": "404"}},
+								{"key": "retry", "value": {"boolValue": true}},
+								{"key": "ratio", "value": {"doubleValue": 0.5}},
+								{"key": "labels", "value": {"arrayValue": {"values": [{"stringValue": "a"}, {"stringValue": "b"}]}}}
+							],
+							"events": [
+								{
+									"timeUnixNano": "1560276970000000000",
+									"name": "exception",
+									"attributes": [
+										{"key": "exception.type", "value": {"stringValue": "java.io.IOException"}},
+										{"key": "exception.message", "value": {"stringValue": "file not found"}}
+									]
+								}
+							],
+							"links": [
+								{
+									"traceId": "0000000000000000000000000000cccc",
+									"spanId": "000000000000bbbb",
+									"attributes": [{"key": "opentracing.ref_type", "value": {"stringValue": "follows_from"}}]
+								}
+							],
+							"flags": 769,
+							"status": {"code": 2, "message": "not found"}
+						}
+					]
+				}
+			]
+		}
+	]
+}`
@@ -0,0 +1,313 @@
+package codec
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/cache"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Parses an opentelemetry ExportTraceServiceRequest encoded using protocol buffers.
+func ParseOtlpProto(input io.Reader) ([]proxy.Span, error) {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read otlp body")
+	}
+
+	var parsedSpans []proxy.Span
+
+	err = decodeProtoMessage(body, func(field protoField) error {
+		if field.Number != 1 || field.Type != protowire.BytesType {
+			return nil
+		}
+
+		return decodeOtlpResourceSpans(field.Bytes, &parsedSpans)
+	})
+
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse spans for otlp")
+	}
+
+	return parsedSpans, nil
+}
+
+func decodeOtlpResourceSpans(b []byte, result *[]proxy.Span) error {
+	var resource otlpAttributes
+	var scopeSpans [][]byte
+
+	// we need the resource to convert the spans, but the resource might
+	// be encoded after the spans, so we collect the spans first.
+	err := decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			resource = otlpAttributes{}
+
+			return decodeProtoMessage(field.Bytes, func(field protoField) error {
+				if field.Number == 1 {
+					return decodeOtlpProtoKeyValue(field.Bytes, resource, 0)
+				}
+
+				return nil
+			})
+
+		case 2, 1000:
+			// 1000 is the deprecated instrumentation_library_spans field
+			scopeSpans = append(scopeSpans, field.Bytes)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return errors.WithMessage(err, "resource spans")
+	}
+
+	for _, encoded := range scopeSpans {
+		if err := decodeOtlpScopeSpans(encoded, resource, result); err != nil {
+			return errors.WithMessage(err, "scope spans")
+		}
+	}
+
+	return nil
+}
+
+func decodeOtlpScopeSpans(b []byte, resource otlpAttributes, result *[]proxy.Span) error {
+	var scope string
+	var spans []otlpSpan
+
+	err := decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			return decodeProtoMessage(field.Bytes, func(field protoField) error {
+				if field.Number == 1 {
+					scope = cache.StringForByteSliceCopy(field.Bytes)
+				}
+
+				return nil
+			})
+
+		case 2:
+			var span otlpSpan
+			if err := decodeOtlpProtoSpan(field.Bytes, &span); err != nil {
+				return err
+			}
+
+			spans = append(spans, span)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for idx := range spans {
+		*result = append(*result, spans[idx].ToSpan(resource, scope))
+	}
+
+	return nil
+}
+
+func decodeOtlpProtoSpan(b []byte, span *otlpSpan) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			span.TraceID = traceIdFromBytes(field.Bytes)
+
+		case 2:
+			span.ID = idFromBytes(field.Bytes)
+
+		case 4:
+			span.ParentID = idFromBytes(field.Bytes)
+
+		case 5:
+			span.Name = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 6:
+			span.Kind = int64(field.Varint)
+
+		case 7:
+			span.Start = field.Varint
+
+		case 8:
+			span.End = field.Varint
+
+		case 9:
+			if span.Attributes == nil {
+				span.Attributes = otlpAttributes{}
+			}
+
+			return errors.WithMessage(
+				decodeOtlpProtoKeyValue(field.Bytes, span.Attributes, 0),
+				"attributes")
+
+		case 11:
+			var event otlpEvent
+			if err := decodeOtlpProtoEvent(field.Bytes, &event); err != nil {
+				return errors.WithMessage(err, "event")
+			}
+
+			span.Events = append(span.Events, event)
+
+		case 13:
+			var link otlpLink
+			if err := decodeOtlpProtoLink(field.Bytes, &link); err != nil {
+				return errors.WithMessage(err, "link")
+			}
+
+			span.Links = append(span.Links, link)
+
+		case 15:
+			return decodeProtoMessage(field.Bytes, func(field protoField) error {
+				switch field.Number {
+				case 2:
+					span.StatusMessage = cache.StringForByteSliceCopy(field.Bytes)
+				case 3:
+					span.StatusCode = int64(field.Varint)
+				}
+
+				return nil
+			})
+
+		case 16:
+			span.Flags = uint32(field.Varint)
+		}
+
+		return nil
+	})
+}
+
+func decodeOtlpProtoEvent(b []byte, event *otlpEvent) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			event.Timestamp = field.Varint
+
+		case 2:
+			event.Name = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 3:
+			if event.Attributes == nil {
+				event.Attributes = otlpAttributes{}
+			}
+
+			return decodeOtlpProtoKeyValue(field.Bytes, event.Attributes, 0)
+		}
+
+		return nil
+	})
+}
+
+func decodeOtlpProtoLink(b []byte, link *otlpLink) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			link.TraceID = traceIdFromBytes(field.Bytes)
+
+		case 2:
+			link.SpanID = idFromBytes(field.Bytes)
+
+		case 4:
+			if link.Attributes == nil {
+				link.Attributes = otlpAttributes{}
+			}
+
+			return decodeOtlpProtoKeyValue(field.Bytes, link.Attributes, 0)
+		}
+
+		return nil
+	})
+}
+
+// Decodes a KeyValue message and puts it into the attributes map. The depth is the
+// nesting of the KeyValue within array and kvlist values of other attributes.
+func decodeOtlpProtoKeyValue(b []byte, attributes otlpAttributes, depth int) error {
+	var key string
+	var value interface{}
+
+	err := decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			key = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 2:
+			var err error
+			value, err = decodeOtlpProtoAnyValue(field.Bytes, depth)
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	attributes[key] = value
+	return nil
+}
+
+func decodeOtlpProtoAnyValue(b []byte, depth int) (interface{}, error) {
+	if depth > otlpMaxValueDepth {
+		return nil, errors.New("otlp attribute value nested too deep")
+	}
+
+	var value interface{}
+
+	err := decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			value = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 2:
+			value = protowire.DecodeBool(field.Varint)
+
+		case 3:
+			value = int64(field.Varint)
+
+		case 4:
+			value = math.Float64frombits(field.Varint)
+
+		case 5:
+			values := []interface{}{}
+
+			err := decodeProtoMessage(field.Bytes, func(field protoField) error {
+				if field.Number != 1 {
+					return nil
+				}
+
+				element, err := decodeOtlpProtoAnyValue(field.Bytes, depth+1)
+				values = append(values, element)
+				return err
+			})
+
+			value = values
+			return err
+
+		case 6:
+			values := otlpAttributes{}
+
+			err := decodeProtoMessage(field.Bytes, func(field protoField) error {
+				if field.Number != 1 {
+					return nil
+				}
+
+				return decodeOtlpProtoKeyValue(field.Bytes, values, depth+1)
+			})
+
+			value = map[string]interface{}(values)
+			return err
+
+		case 7:
+			value = append([]byte(nil), field.Bytes...)
+		}
+
+		return nil
+	})
+
+	return value, err
+}
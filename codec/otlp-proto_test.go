@@ -0,0 +1,226 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseOtlpProto(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseOtlpProto(bytes.NewReader(encodedOtlpProto()))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0]).To(Equal(expectedOtlpSpan(tagOtlp)))
+}
+
+func TestParseOtlpProto_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := encodedOtlpProto()
+
+	_, err := ParseOtlpProto(bytes.NewReader(encoded[:len(encoded)-1]))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseOtlpProto_NestedTooDeep(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	nestedRequest := func(depth int) []byte {
+		var value []byte
+		value = appendProtoString(value, 1, "leaf")
+
+		for idx := 0; idx < depth; idx++ {
+			var array []byte
+			array = appendProtoMessage(array, 1, value)
+
+			value = appendProtoMessage(nil, 5, array)
+		}
+
+		var keyValue []byte
+		keyValue = appendProtoString(keyValue, 1, "nested")
+		keyValue = appendProtoMessage(keyValue, 2, value)
+
+		var span []byte
+		span = appendProtoMessage(span, 9, keyValue)
+
+		var scopeSpans []byte
+		scopeSpans = appendProtoMessage(scopeSpans, 2, span)
+
+		var resourceSpans []byte
+		resourceSpans = appendProtoMessage(resourceSpans, 2, scopeSpans)
+
+		return appendProtoMessage(nil, 1, resourceSpans)
+	}
+
+	_, err := ParseOtlpProto(bytes.NewReader(nestedRequest(otlpMaxValueDepth)))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = ParseOtlpProto(bytes.NewReader(nestedRequest(otlpMaxValueDepth + 1)))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func expectedOtlpSpan(protocolVersion string) proxy.Span {
+	return proxy.Span{
+		Id:        0xdead,
+		Trace:     0xbeaf,
+		TraceHigh: 1,
+		Parent:    0xaaaa,
+		Name:      "GET /my/path",
+		Service:   "my-service",
+		Kind:      proxy.KindServer,
+		Sampling:  proxy.Sampling{Decided: true, Priority: proxy.PriorityAutoKeep},
+
+		Timestamp: proxy.Timestamp(1560276970 * time.Second),
+		Duration:  50 * time.Millisecond,
+
+		Tags: map[string]string{
+			"env":               "prod",
+			"otel.library.name": "my-library",
+			"http.path":         "/my/path",
+			"http.status":       "404",
+			"retry":             "true",
+			"ratio":             "0.5",
+			"labels":            `["a","b"]`,
+			"error":             "true",
+			"error.type":        "java.io.IOException",
+			"error.msg":         "not found",
+			tagProtocolVersion:  protocolVersion,
+		},
+		Metrics: map[string]float64{"http.status": 404, "ratio": 0.5},
+
+		Events: []proxy.Event{
+			{
+				Timestamp: proxy.Timestamp(1560276970 * time.Second),
+				Name:      "exception",
+				Tags: map[string]string{
+					"exception.type":    "java.io.IOException",
+					"exception.message": "file not found",
+				},
+			},
+		},
+
+		Links: []proxy.Link{
+			{
+				Trace:       proxy.TraceId{Low: 0xcccc},
+				Span:        0xbbbb,
+				FollowsFrom: true,
+				Tags:        map[string]string{otlpAttributeRefType: "follows_from"},
+			},
+		},
+
+		Timings: proxy.Timings{
+			SR: proxy.Timestamp(1560276970 * time.Second),
+			SS: proxy.Timestamp(1560276970*time.Second + 50*time.Millisecond),
+		},
+	}
+}
+
+func appendProtoAnyValue(b []byte, num protowire.Number, value interface{}) []byte {
+	var encoded []byte
+
+	switch value := value.(type) {
+	case string:
+		encoded = appendProtoString(encoded, 1, value)
+
+	case bool:
+		encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, protowire.EncodeBool(value))
+
+	case int:
+		encoded = protowire.AppendTag(encoded, 3, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, uint64(value))
+
+	case float64:
+		encoded = protowire.AppendTag(encoded, 4, protowire.Fixed64Type)
+		encoded = protowire.AppendFixed64(encoded, math.Float64bits(value))
+
+	case []string:
+		var array []byte
+		for _, element := range value {
+			array = appendProtoAnyValue(array, 1, element)
+		}
+
+		encoded = appendProtoMessage(encoded, 5, array)
+	}
+
+	return appendProtoMessage(b, num, encoded)
+}
+
+func appendProtoKeyValue(b []byte, num protowire.Number, key string, value interface{}) []byte {
+	var keyValue []byte
+	keyValue = appendProtoString(keyValue, 1, key)
+	keyValue = appendProtoAnyValue(keyValue, 2, value)
+	return appendProtoMessage(b, num, keyValue)
+}
+
+func encodedOtlpProto() []byte {
+	var span []byte
+	span = appendProtoMessage(span, 1, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xbe, 0xaf})
+	span = appendProtoMessage(span, 2, []byte{0, 0, 0, 0, 0, 0, 0xde, 0xad})
+	span = appendProtoMessage(span, 4, []byte{0, 0, 0, 0, 0, 0, 0xaa, 0xaa})
+	span = appendProtoString(span, 5, "GET /my/path")
+
+	span = protowire.AppendTag(span, 6, protowire.VarintType)
+	span = protowire.AppendVarint(span, otlpSpanKindServer)
+
+	span = protowire.AppendTag(span, 7, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, uint64(1560276970*time.Second))
+
+	span = protowire.AppendTag(span, 8, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, uint64(1560276970*time.Second+50*time.Millisecond))
+
+	span = appendProtoKeyValue(span, 9, "http.path", "/my/path")
+	span = appendProtoKeyValue(span, 9, "http.status", 404)
+	span = appendProtoKeyValue(span, 9, "retry", true)
+	span = appendProtoKeyValue(span, 9, "ratio", 0.5)
+	span = appendProtoKeyValue(span, 9, "labels", []string{"a", "b"})
+
+	var event []byte
+	event = protowire.AppendTag(event, 1, protowire.Fixed64Type)
+	event = protowire.AppendFixed64(event, uint64(1560276970*time.Second))
+	event = appendProtoString(event, 2, "exception")
+	event = appendProtoKeyValue(event, 3, "exception.type", "java.io.IOException")
+	event = appendProtoKeyValue(event, 3, "exception.message", "file not found")
+	span = appendProtoMessage(span, 11, event)
+
+	var link []byte
+	link = appendProtoMessage(link, 1, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xcc, 0xcc})
+	link = appendProtoMessage(link, 2, []byte{0, 0, 0, 0, 0, 0, 0xbb, 0xbb})
+	link = appendProtoKeyValue(link, 4, otlpAttributeRefType, "follows_from")
+	span = appendProtoMessage(span, 13, link)
+
+	var status []byte
+	status = appendProtoString(status, 2, "not found")
+	status = protowire.AppendTag(status, 3, protowire.VarintType)
+	status = protowire.AppendVarint(status, otlpStatusCodeError)
+	span = appendProtoMessage(span, 15, status)
+
+	// sampled, with a remote parent
+	span = protowire.AppendTag(span, 16, protowire.Fixed32Type)
+	span = protowire.AppendFixed32(span, 0x301)
+
+	var scope []byte
+	scope = appendProtoString(scope, 1, "my-library")
+
+	var scopeSpans []byte
+	scopeSpans = appendProtoMessage(scopeSpans, 1, scope)
+	scopeSpans = appendProtoMessage(scopeSpans, 2, span)
+
+	var resource []byte
+	resource = appendProtoKeyValue(resource, 1, "service.name", "my-service")
+	resource = appendProtoKeyValue(resource, 1, "deployment.environment", "prod")
+
+	// put the resource after the spans to check that we handle this correctly
+	var resourceSpans []byte
+	resourceSpans = appendProtoMessage(resourceSpans, 2, scopeSpans)
+	resourceSpans = appendProtoMessage(resourceSpans, 1, resource)
+
+	return appendProtoMessage(nil, 1, resourceSpans)
+}
@@ -0,0 +1,248 @@
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"strconv"
+	"time"
+)
+
+// span kinds as defined by the opentelemetry protocol
+const (
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+)
+
+const otlpStatusCodeError = 2
+
+// the sampled bit of the w3c trace flags
+const otlpFlagSampled = 1
+
+// attribute the opentracing shim and the jaeger receiver set on links created from references
+const otlpAttributeRefType = "opentracing.ref_type"
+
+// maximum nesting of array and key value list attribute values before giving up.
+const otlpMaxValueDepth = 64
+
+// Attribute values are decoded to string, bool, int64, float64, []byte,
+// []interface{} for arrays and map[string]interface{} for key value lists.
+type otlpAttributes map[string]interface{}
+
+type otlpEvent struct {
+	Timestamp  uint64
+	Name       string
+	Attributes otlpAttributes
+}
+
+type otlpLink struct {
+	TraceID    proxy.TraceId
+	SpanID     Id
+	Attributes otlpAttributes
+}
+
+// Intermediate representation of an opentelemetry span, shared
+// by the protobuf and the json decoder.
+type otlpSpan struct {
+	TraceID  proxy.TraceId
+	ID       Id
+	ParentID Id
+
+	Name string
+	Kind int64
+
+	// start and end time in nanoseconds since epoch
+	Start uint64
+	End   uint64
+
+	Attributes otlpAttributes
+	Events     []otlpEvent
+	Links      []otlpLink
+
+	// w3c trace flags in the lower 8 bits
+	Flags uint32
+
+	StatusCode    int64
+	StatusMessage string
+}
+
+func (span *otlpSpan) ToSpan(resource otlpAttributes, scope string) proxy.Span {
+	proxySpan := proxy.NewSpan(span.Name, span.TraceID.Low, span.ID, span.ParentID)
+	proxySpan.TraceHigh = span.TraceID.High
+
+	if service, ok := resource["service.name"].(string); ok {
+		proxySpan.Service = service
+	}
+
+	proxySpan.Tags = make(map[string]string, 4+len(span.Attributes))
+
+	if env, ok := resource["deployment.environment"].(string); ok {
+		proxySpan.AddTag("env", env)
+	}
+
+	if version, ok := resource["service.version"].(string); ok {
+		proxySpan.AddTag("version", version)
+	}
+
+	if scope != "" {
+		proxySpan.AddTag("otel.library.name", scope)
+	}
+
+	for key, value := range span.Attributes {
+		addTagValue(&proxySpan, key, otlpTagValue(value))
+	}
+
+	for _, event := range span.Events {
+		proxySpan.AddEvent(event.ToEvent())
+
+		if event.Name != "exception" {
+			continue
+		}
+
+		// map exception events to the error tags datadog understands
+		if value, ok := event.Attributes["exception.type"]; ok {
+			proxySpan.AddTag("error.type", otlpValueToString(value))
+		}
+
+		if value, ok := event.Attributes["exception.message"]; ok {
+			proxySpan.AddTag("error.msg", otlpValueToString(value))
+		}
+
+		if value, ok := event.Attributes["exception.stacktrace"]; ok {
+			proxySpan.AddTag("error.stack", otlpValueToString(value))
+		}
+	}
+
+	if span.StatusCode == otlpStatusCodeError {
+		proxySpan.AddTag("error", "true")
+
+		if span.StatusMessage != "" {
+			proxySpan.AddTag("error.msg", span.StatusMessage)
+		}
+	}
+
+	for _, link := range span.Links {
+		proxySpan.AddLink(link.ToLink())
+	}
+
+	// only sampled spans are exported, so a missing sampled bit is not a decision
+	if span.Flags&otlpFlagSampled != 0 {
+		proxySpan.Sampling.SetSampled(true)
+	}
+
+	takeSamplingPriorityTag(&proxySpan)
+
+	proxySpan.AddTag(tagProtocolVersion, tagOtlp)
+
+	proxySpan.Timestamp = proxy.Timestamp(span.Start)
+
+	if span.End > span.Start {
+		proxySpan.Duration = time.Duration(span.End - span.Start)
+	}
+
+	if proxySpan.Duration == 0 {
+		proxySpan.Duration = 1 * time.Millisecond
+	}
+
+	switch span.Kind {
+	case otlpSpanKindClient:
+		proxySpan.Kind = proxy.KindClient
+
+	case otlpSpanKindServer:
+		proxySpan.Kind = proxy.KindServer
+
+	case otlpSpanKindProducer:
+		proxySpan.Kind = proxy.KindProducer
+
+	case otlpSpanKindConsumer:
+		proxySpan.Kind = proxy.KindConsumer
+	}
+
+	fillInTimings(&proxySpan)
+
+	return proxySpan
+}
+
+func (event *otlpEvent) ToEvent() proxy.Event {
+	result := proxy.Event{
+		Timestamp: proxy.Timestamp(event.Timestamp),
+		Name:      event.Name,
+	}
+
+	result.Tags = event.Attributes.ToTags()
+
+	return result
+}
+
+func (link *otlpLink) ToLink() proxy.Link {
+	result := proxy.Link{
+		Trace: link.TraceID,
+		Span:  link.SpanID,
+	}
+
+	if refType, ok := link.Attributes[otlpAttributeRefType].(string); ok {
+		result.FollowsFrom = refType == "follows_from"
+	}
+
+	result.Tags = link.Attributes.ToTags()
+
+	return result
+}
+
+// Formats all attributes as string, returns nil if there are no attributes.
+func (attributes otlpAttributes) ToTags() map[string]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	tags := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		tags[key] = otlpValueToString(value)
+	}
+
+	return tags
+}
+
+// Numbers keep their type, all other values are formatted as string.
+func otlpTagValue(value interface{}) tagValue {
+	switch value := value.(type) {
+	case int64:
+		return intTagValue(value)
+
+	case float64:
+		return floatTagValue(value)
+
+	default:
+		return textTagValue(otlpValueToString(value))
+	}
+}
+
+// Formats an attribute value as a string. Arrays and key value lists are
+// encoded as json, bytes are base64 encoded.
+func otlpValueToString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case bool:
+		return strconv.FormatBool(value)
+
+	case int64:
+		return strconv.FormatInt(value, 10)
+
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+
+	case []byte:
+		return base64.StdEncoding.EncodeToString(value)
+
+	case nil:
+		return ""
+
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
@@ -0,0 +1,169 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/cache"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"io/ioutil"
+)
+
+// names of the span kinds as defined in zipkin.proto3
+var protoSpanKinds = []string{"", "CLIENT", "SERVER", "PRODUCER", "CONSUMER"}
+
+// Parses a zipkin v2 ListOfSpans message encoded using protocol buffers.
+func ParseProtoV2(input io.Reader) ([]proxy.Span, error) {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read proto v2 body")
+	}
+
+	var parsedSpans []proxy.Span
+
+	err = decodeProtoMessage(body, func(field protoField) error {
+		if field.Number != 1 || field.Type != protowire.BytesType {
+			return nil
+		}
+
+		var span spanV2
+		if err := decodeProtoSpanV2(field.Bytes, &span); err != nil {
+			return err
+		}
+
+		proxySpan := span.ToSpan()
+		proxySpan.AddTag(tagProtocolVersion, tagProtoV2)
+
+		parsedSpans = append(parsedSpans, proxySpan)
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse spans for proto v2")
+	}
+
+	return parsedSpans, nil
+}
+
+func decodeProtoSpanV2(b []byte, span *spanV2) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			span.TraceID = traceIdFromBytes(field.Bytes)
+
+		case 2:
+			span.ParentID = idFromBytes(field.Bytes)
+
+		case 3:
+			span.ID = idFromBytes(field.Bytes)
+
+		case 4:
+			if field.Varint < uint64(len(protoSpanKinds)) {
+				span.Kind = protoSpanKinds[field.Varint]
+			}
+
+		case 5:
+			span.Name = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 6:
+			span.Timestamp = field.Varint
+
+		case 7:
+			span.Duration = field.Varint
+
+		case 8:
+			return errors.WithMessage(
+				decodeProtoEndpoint(field.Bytes, &span.Endpoint),
+				"local endpoint")
+
+		case 9:
+			return errors.WithMessage(
+				decodeProtoEndpoint(field.Bytes, &span.RemoteEndpoint),
+				"remote endpoint")
+
+		case 10:
+			var annotation annotationV2
+			if err := decodeProtoAnnotation(field.Bytes, &annotation); err != nil {
+				return errors.WithMessage(err, "annotations")
+			}
+
+			span.Annotations = append(span.Annotations, annotation)
+
+		case 11:
+			key, value, err := decodeProtoMapEntry(field.Bytes)
+			if err != nil {
+				return errors.WithMessage(err, "tags")
+			}
+
+			if span.Tags == nil {
+				span.Tags = make(map[string]tagValue)
+			}
+
+			// tags are always strings in the protobuf format
+			span.Tags[key] = textTagValue(value)
+
+		case 12:
+			span.Debug = field.Varint != 0
+
+		case 13:
+			span.Shared = field.Varint != 0
+		}
+
+		return nil
+	})
+}
+
+func decodeProtoEndpoint(b []byte, endpoint *endpoint) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			endpoint.ServiceName = cache.StringForByteSliceCopy(field.Bytes)
+
+		case 2:
+			if len(field.Bytes) == 4 {
+				endpoint.Ipv4 = ipv4ToString(binary.BigEndian.Uint32(field.Bytes))
+			}
+
+		case 3:
+			endpoint.Ipv6 = ipv6ToString(field.Bytes)
+
+		case 4:
+			endpoint.Port = uint64(uint16(field.Varint))
+		}
+
+		return nil
+	})
+}
+
+func decodeProtoAnnotation(b []byte, annotation *annotationV2) error {
+	return decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			annotation.Timestamp = field.Varint
+
+		case 2:
+			annotation.Value = cache.StringForByteSliceCopy(field.Bytes)
+		}
+
+		return nil
+	})
+}
+
+// Decodes a map<string, string> entry.
+func decodeProtoMapEntry(b []byte) (string, string, error) {
+	var key, value string
+
+	err := decodeProtoMessage(b, func(field protoField) error {
+		switch field.Number {
+		case 1:
+			key = cache.StringForByteSliceCopy(field.Bytes)
+		case 2:
+			value = cache.StringForByteSliceCopy(field.Bytes)
+		}
+
+		return nil
+	})
+
+	return key, value, err
+}
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseProtoV2(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseProtoV2(bytes.NewReader(encodedProtoV2()))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+
+	g.Expect(spans[0]).To(Equal(proxy.Span{
+		Id:        0xdead,
+		Trace:     0xbeaf,
+		TraceHigh: 1,
+		Parent:    0xbeaf,
+		Name:      "span name",
+		Service:   "my-service",
+		Kind:      proxy.KindClient,
+
+		LocalEndpoint:  &proxy.Endpoint{ServiceName: "my-service", IPv4: "127.0.0.1"},
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "postgres", IPv6: "2001:db8::2", Port: 5432},
+
+		Timestamp: proxy.Timestamp(1560276970 * time.Second),
+		Duration:  50 * time.Millisecond,
+
+		Tags: map[string]string{
+			"http.path":        "/my/path",
+			"http.status":      "404",
+			tagProtocolVersion: tagProtoV2,
+		},
+
+		Events: []proxy.Event{
+			{Timestamp: proxy.Timestamp(1560276970*time.Second + 10*time.Millisecond), Name: "ws"},
+		},
+
+		Timings: proxy.Timings{
+			CS: proxy.Timestamp(1560276970 * time.Second),
+			CR: proxy.Timestamp(1560276970*time.Second + 50*time.Millisecond),
+		},
+	}))
+}
+
+func TestParseProtoV2_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := ParseProtoV2(bytes.NewReader([]byte{0x0a, 0xff}))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func BenchmarkParseProtoV2(b *testing.B) {
+	data := encodedProtoV2()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var sum proxy.Id
+		for pb.Next() {
+			spans, _ := ParseProtoV2(bytes.NewReader(data))
+			for _, span := range spans {
+				sum += span.Id
+			}
+		}
+	})
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendProtoMapEntry(b []byte, num protowire.Number, key, value string) []byte {
+	var entry []byte
+	entry = appendProtoString(entry, 1, key)
+	entry = appendProtoString(entry, 2, value)
+	return appendProtoMessage(b, num, entry)
+}
+
+func encodedProtoV2() []byte {
+	var endpoint []byte
+	endpoint = appendProtoString(endpoint, 1, "my-service")
+	endpoint = appendProtoMessage(endpoint, 2, []byte{127, 0, 0, 1})
+
+	var span []byte
+
+	// 128 bit trace id
+	span = appendProtoMessage(span, 1, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xbe, 0xaf})
+	span = appendProtoMessage(span, 2, []byte{0, 0, 0, 0, 0, 0, 0xbe, 0xaf})
+	span = appendProtoMessage(span, 3, []byte{0, 0, 0, 0, 0, 0, 0xde, 0xad})
+
+	span = protowire.AppendTag(span, 4, protowire.VarintType)
+	span = protowire.AppendVarint(span, 1)
+
+	span = appendProtoString(span, 5, "span name")
+
+	span = protowire.AppendTag(span, 6, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, 1560276970000000)
+
+	span = protowire.AppendTag(span, 7, protowire.VarintType)
+	span = protowire.AppendVarint(span, 50000)
+
+	span = appendProtoMessage(span, 8, endpoint)
+
+	var remoteEndpoint []byte
+	remoteEndpoint = appendProtoString(remoteEndpoint, 1, "postgres")
+	remoteEndpoint = appendProtoMessage(remoteEndpoint, 3, net.ParseIP("2001:db8::2"))
+	remoteEndpoint = protowire.AppendTag(remoteEndpoint, 4, protowire.VarintType)
+	remoteEndpoint = protowire.AppendVarint(remoteEndpoint, 5432)
+	span = appendProtoMessage(span, 9, remoteEndpoint)
+
+	var annotation []byte
+	annotation = protowire.AppendTag(annotation, 1, protowire.Fixed64Type)
+	annotation = protowire.AppendFixed64(annotation, 1560276970010000)
+	annotation = appendProtoString(annotation, 2, "ws")
+	span = appendProtoMessage(span, 10, annotation)
+
+	span = appendProtoMapEntry(span, 11, "http.path", "/my/path")
+	span = appendProtoMapEntry(span, 11, "http.status", "404")
+
+	// unknown field that must be skipped
+	span = protowire.AppendTag(span, 99, protowire.Fixed32Type)
+	span = protowire.AppendFixed32(span, 42)
+
+	return appendProtoMessage(nil, 1, span)
+}
@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A single field of a protobuf encoded message. Depending on the wire type,
+// the value is either stored in Varint (for varint and fixed size values)
+// or in Bytes (for length delimited values).
+type protoField struct {
+	Number protowire.Number
+	Type   protowire.Type
+
+	Varint uint64
+	Bytes  []byte
+}
+
+// Iterates over all fields of the protobuf encoded message and calls
+// the given callback for each of them. Groups are skipped.
+// Bytes values passed to the callback share memory with the input.
+func decodeProtoMessage(b []byte, callback func(field protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		field := protoField{Number: num, Type: typ}
+
+		switch typ {
+		case protowire.VarintType:
+			field.Varint, n = protowire.ConsumeVarint(b)
+
+		case protowire.Fixed32Type:
+			var value uint32
+			value, n = protowire.ConsumeFixed32(b)
+			field.Varint = uint64(value)
+
+		case protowire.Fixed64Type:
+			field.Varint, n = protowire.ConsumeFixed64(b)
+
+		case protowire.BytesType:
+			field.Bytes, n = protowire.ConsumeBytes(b)
+
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		if typ == protowire.StartGroupType {
+			continue
+		}
+
+		if err := callback(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Converts a big endian encoded id to an Id value. If the value is longer
+// than 64 bits, only the lower 64 bits are used.
+func idFromBytes(b []byte) Id {
+	if len(b) > 8 {
+		b = b[len(b)-8:]
+	}
+
+	var buf [8]byte
+	copy(buf[8-len(b):], b)
+
+	return Id(binary.BigEndian.Uint64(buf[:]))
+}
+
+// Converts a big endian encoded trace id of up to 128 bits to a TraceId value.
+func traceIdFromBytes(b []byte) proxy.TraceId {
+	if len(b) <= 8 {
+		return proxy.TraceId{Low: idFromBytes(b)}
+	}
+
+	return proxy.TraceId{
+		High: idFromBytes(b[:len(b)-8]),
+		Low:  idFromBytes(b[len(b)-8:]),
+	}
+}
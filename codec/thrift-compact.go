@@ -0,0 +1,272 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/cache"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+)
+
+const thriftCompactProtocolId = 0x82
+const thriftCompactVersion = 1
+
+// maps the type ids of the compact protocol to the ones of the binary protocol.
+var thriftCompactTypes = [...]byte{
+	0:  thriftTypeStop,
+	1:  thriftTypeBool,
+	2:  thriftTypeBool,
+	3:  thriftTypeByte,
+	4:  thriftTypeI16,
+	5:  thriftTypeI32,
+	6:  thriftTypeI64,
+	7:  thriftTypeDouble,
+	8:  thriftTypeString,
+	9:  thriftTypeList,
+	10: thriftTypeSet,
+	11: thriftTypeMap,
+	12: thriftTypeStruct,
+}
+
+// Reads values encoded with the thrift compact protocol from an in memory buffer.
+// Binary values returned by the reader share memory with the buffer.
+type thriftCompactReader struct {
+	buf []byte
+	pos int
+
+	// id of the previous field and the ids of the enclosing structs,
+	// field ids are delta encoded in the compact protocol.
+	lastFieldId  int16
+	lastFieldIds []int16
+
+	// the value of a boolean field is encoded in the field header
+	boolValue        bool
+	boolValuePending bool
+}
+
+func (r *thriftCompactReader) remaining() int {
+	return len(r.buf) - r.pos
+}
+
+func (r *thriftCompactReader) next(n int) ([]byte, error) {
+	if n < 0 || n > r.remaining() {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	value := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return value, nil
+}
+
+func (r *thriftCompactReader) readVarint() (uint64, error) {
+	value, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+
+	r.pos += n
+	return value, nil
+}
+
+func (r *thriftCompactReader) readZigZag() (int64, error) {
+	value, err := r.readVarint()
+	return int64(value>>1) ^ -int64(value&1), err
+}
+
+func (r *thriftCompactReader) compactType(compactType byte) (byte, error) {
+	if int(compactType) >= len(thriftCompactTypes) {
+		return 0, errors.Errorf("unknown thrift compact type %d", compactType)
+	}
+
+	return thriftCompactTypes[compactType], nil
+}
+
+// Reads the header of a thrift message and returns the name of the called method.
+func (r *thriftCompactReader) ReadMessageBegin() (string, error) {
+	protocolId, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if protocolId != thriftCompactProtocolId {
+		return "", errors.Errorf("expected compact protocol id, got %x", protocolId)
+	}
+
+	versionAndType, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if versionAndType&0x1f != thriftCompactVersion {
+		return "", errors.Errorf("unsupported compact protocol version %d", versionAndType&0x1f)
+	}
+
+	// the sequence id
+	if _, err := r.readVarint(); err != nil {
+		return "", err
+	}
+
+	return r.ReadString()
+}
+
+func (r *thriftCompactReader) ReadStructBegin() {
+	r.lastFieldIds = append(r.lastFieldIds, r.lastFieldId)
+	r.lastFieldId = 0
+}
+
+func (r *thriftCompactReader) ReadStructEnd() {
+	if len(r.lastFieldIds) > 0 {
+		r.lastFieldId = r.lastFieldIds[len(r.lastFieldIds)-1]
+		r.lastFieldIds = r.lastFieldIds[:len(r.lastFieldIds)-1]
+	}
+}
+
+func (r *thriftCompactReader) ReadFieldBegin() (byte, int16, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fieldType, err := r.compactType(header & 0x0f)
+	if err != nil || fieldType == thriftTypeStop {
+		return fieldType, 0, err
+	}
+
+	if delta := int16(header >> 4); delta != 0 {
+		r.lastFieldId += delta
+	} else {
+		fieldId, err := r.readZigZag()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		r.lastFieldId = int16(fieldId)
+	}
+
+	if fieldType == thriftTypeBool {
+		r.boolValue = header&0x0f == 1
+		r.boolValuePending = true
+	}
+
+	return fieldType, r.lastFieldId, nil
+}
+
+func (r *thriftCompactReader) ReadListBegin() (byte, int, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	elementType, err := r.compactType(header & 0x0f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := uint64(header >> 4)
+	if size == 15 {
+		if size, err = r.readVarint(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// each element needs at least one byte, so this is a cheap check
+	// to not allocate huge slices on broken input.
+	if size > uint64(r.remaining()) {
+		return 0, 0, errors.Errorf("invalid thrift list size %d", size)
+	}
+
+	return elementType, int(size), nil
+}
+
+func (r *thriftCompactReader) ReadMapBegin() (byte, byte, int, error) {
+	size, err := r.readVarint()
+	if err != nil || size == 0 {
+		return 0, 0, 0, err
+	}
+
+	if size > uint64(r.remaining()) {
+		return 0, 0, 0, errors.Errorf("invalid thrift map size %d", size)
+	}
+
+	types, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	keyType, err := r.compactType(types >> 4)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	valueType, err := r.compactType(types & 0x0f)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return keyType, valueType, int(size), nil
+}
+
+func (r *thriftCompactReader) ReadBool() (bool, error) {
+	if r.boolValuePending {
+		r.boolValuePending = false
+		return r.boolValue, nil
+	}
+
+	// booleans in containers are encoded as single byte
+	value, err := r.ReadByte()
+	return value == 1, err
+}
+
+func (r *thriftCompactReader) ReadByte() (byte, error) {
+	value, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return value[0], nil
+}
+
+func (r *thriftCompactReader) ReadI16() (int16, error) {
+	value, err := r.readZigZag()
+	return int16(value), err
+}
+
+func (r *thriftCompactReader) ReadI32() (int32, error) {
+	value, err := r.readZigZag()
+	return int32(value), err
+}
+
+func (r *thriftCompactReader) ReadI64() (int64, error) {
+	return r.readZigZag()
+}
+
+func (r *thriftCompactReader) ReadDouble() (float64, error) {
+	value, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(value)), nil
+}
+
+func (r *thriftCompactReader) ReadBinary() ([]byte, error) {
+	length, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if length > uint64(r.remaining()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return r.next(int(length))
+}
+
+func (r *thriftCompactReader) ReadString() (string, error) {
+	value, err := r.ReadBinary()
+	if err != nil {
+		return "", err
+	}
+
+	return cache.StringForByteSliceCopy(value), nil
+}
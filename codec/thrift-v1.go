@@ -0,0 +1,273 @@
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/cache"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// annotation types of zipkin v1 binary annotations
+const (
+	annotationTypeBool   = 0
+	annotationTypeBytes  = 1
+	annotationTypeI16    = 2
+	annotationTypeI32    = 3
+	annotationTypeI64    = 4
+	annotationTypeDouble = 5
+	annotationTypeString = 6
+)
+
+// Parses a list of zipkin v1 spans encoded using the thrift binary protocol.
+// This is what zipkin reporters send when configured with the thrift codec.
+func ParseThriftV1(input io.Reader) ([]proxy.Span, error) {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read thrift v1 body")
+	}
+
+	r := &thriftBinaryReader{buf: body}
+
+	elementType, size, err := r.ReadListBegin()
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse spans for thrift v1")
+	}
+
+	if elementType != thriftTypeStruct {
+		return nil, errors.Errorf("expected list of spans, got list of type %d", elementType)
+	}
+
+	parsedSpans := make([]proxy.Span, 0, size)
+
+	var span spanV1
+	for idx := 0; idx < size; idx++ {
+		if err := readThriftSpanV1(r, &span); err != nil {
+			return nil, errors.WithMessage(err, "parse spans for thrift v1")
+		}
+
+		proxySpan := span.ToSpan()
+		proxySpan.AddTag(tagProtocolVersion, tagThriftV1)
+
+		parsedSpans = append(parsedSpans, proxySpan)
+	}
+
+	return parsedSpans, nil
+}
+
+func readThriftSpanV1(r thriftReader, span *spanV1) error {
+	// we are re-using the span, so clear it before decoding into it
+	span.TraceID = proxy.TraceId{}
+	span.ID = 0
+	span.ParentID = 0
+	span.Duration = 0
+	span.Timestamp = 0
+	span.Name = ""
+	span.Debug = false
+
+	for idx := range span.Annotations {
+		span.Annotations[idx] = annotationV1{}
+	}
+
+	span.Annotations = span.Annotations[:0]
+
+	for idx := range span.BinaryAnnotations {
+		span.BinaryAnnotations[idx] = binaryAnnotationV1{}
+	}
+
+	span.BinaryAnnotations = span.BinaryAnnotations[:0]
+
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.TraceID.Low = Id(value)
+
+		case fieldId == 3 && fieldType == thriftTypeString:
+			span.Name, err = r.ReadString()
+
+		case fieldId == 4 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.ID = Id(value)
+
+		case fieldId == 5 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.ParentID = Id(value)
+
+		case fieldId == 6 && fieldType == thriftTypeList:
+			err = readThriftStructList(r, func(r thriftReader) error {
+				var annotation annotationV1
+				if err := readThriftAnnotationV1(r, &annotation); err != nil {
+					return err
+				}
+
+				span.Annotations = append(span.Annotations, annotation)
+				return nil
+			})
+
+			err = errors.WithMessage(err, "annotations")
+
+		case fieldId == 8 && fieldType == thriftTypeList:
+			err = readThriftStructList(r, func(r thriftReader) error {
+				var annotation binaryAnnotationV1
+				if err := readThriftBinaryAnnotationV1(r, &annotation); err != nil {
+					return err
+				}
+
+				span.BinaryAnnotations = append(span.BinaryAnnotations, annotation)
+				return nil
+			})
+
+			err = errors.WithMessage(err, "binary annotations")
+
+		case fieldId == 9 && fieldType == thriftTypeBool:
+			span.Debug, err = r.ReadBool()
+
+		case fieldId == 10 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.Timestamp = uint64(value)
+
+		case fieldId == 11 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.Duration = uint64(value)
+
+		case fieldId == 12 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			span.TraceID.High = Id(value)
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+}
+
+func readThriftAnnotationV1(r thriftReader, annotation *annotationV1) error {
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI64:
+			var value int64
+			value, err = r.ReadI64()
+			annotation.Timestamp = uint64(value)
+
+		case fieldId == 2 && fieldType == thriftTypeString:
+			annotation.Value, err = r.ReadString()
+
+		case fieldId == 3 && fieldType == thriftTypeStruct:
+			err = readThriftEndpoint(r, &annotation.Endpoint)
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+}
+
+func readThriftBinaryAnnotationV1(r thriftReader, annotation *binaryAnnotationV1) error {
+	var value []byte
+	var annotationType int32 = annotationTypeString
+
+	err := readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeString:
+			annotation.Key, err = r.ReadString()
+
+		case fieldId == 2 && fieldType == thriftTypeString:
+			value, err = r.ReadBinary()
+
+		case fieldId == 3 && fieldType == thriftTypeI32:
+			annotationType, err = r.ReadI32()
+
+		case fieldId == 4 && fieldType == thriftTypeStruct:
+			err = readThriftEndpoint(r, &annotation.Endpoint)
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	annotation.Value = binaryAnnotationValue(annotationType, value)
+	return nil
+}
+
+// Decodes the value of a binary annotation. Numbers keep their type, all other
+// values are formatted the same way zipkin formats them when encoding spans to json.
+func binaryAnnotationValue(annotationType int32, value []byte) tagValue {
+	switch {
+	case annotationType == annotationTypeBool && len(value) == 1:
+		return textTagValue(strconv.FormatBool(value[0] != 0))
+
+	case annotationType == annotationTypeI16 && len(value) == 2:
+		return intTagValue(int64(int16(binary.BigEndian.Uint16(value))))
+
+	case annotationType == annotationTypeI32 && len(value) == 4:
+		return intTagValue(int64(int32(binary.BigEndian.Uint32(value))))
+
+	case annotationType == annotationTypeI64 && len(value) == 8:
+		return intTagValue(int64(binary.BigEndian.Uint64(value)))
+
+	case annotationType == annotationTypeDouble && len(value) == 8:
+		return floatTagValue(math.Float64frombits(binary.BigEndian.Uint64(value)))
+
+	case annotationType == annotationTypeString:
+		return textTagValue(cache.StringForByteSliceCopy(value))
+
+	default:
+		return textTagValue(base64.StdEncoding.EncodeToString(value))
+	}
+}
+
+func readThriftEndpoint(r thriftReader, endpoint *endpoint) error {
+	return readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+		var err error
+
+		switch {
+		case fieldId == 1 && fieldType == thriftTypeI32:
+			var value int32
+			value, err = r.ReadI32()
+			endpoint.Ipv4 = ipv4ToString(uint32(value))
+
+		case fieldId == 2 && fieldType == thriftTypeI16:
+			var value int16
+			value, err = r.ReadI16()
+			endpoint.Port = uint64(uint16(value))
+
+		case fieldId == 3 && fieldType == thriftTypeString:
+			endpoint.ServiceName, err = r.ReadString()
+
+		case fieldId == 4 && fieldType == thriftTypeString:
+			var value []byte
+			value, err = r.ReadBinary()
+			endpoint.Ipv6 = ipv6ToString(value)
+
+		default:
+			err = skipThrift(r, fieldType)
+		}
+
+		return err
+	})
+}
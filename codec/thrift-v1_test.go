@@ -0,0 +1,190 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestParseThriftV1(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans, err := ParseThriftV1(bytes.NewReader(encodedThriftV1()))
+
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spans).To(HaveLen(1))
+
+	g.Expect(spans[0]).To(Equal(proxy.Span{
+		Id:        0xdead,
+		Trace:     0xbeaf,
+		TraceHigh: 1,
+		Parent:    0xbeaf,
+		Name:      "span name",
+		Service:   "my-service",
+		Kind:      proxy.KindClient,
+
+		// taken from the b3 'sampled' tag
+		Sampling: proxy.Sampling{Decided: true, Priority: proxy.PriorityAutoKeep},
+
+		LocalEndpoint: &proxy.Endpoint{ServiceName: "my-service", IPv4: "127.0.0.1", Port: 8080},
+
+		// timestamp is also picked from the CS/CR if available
+		Timestamp: proxy.Timestamp(1560276970 * time.Second),
+
+		// duration is taken from the CS/CR if available
+		Duration: 1000 * time.Millisecond,
+
+		Tags: map[string]string{
+			"http.path":        "/my/path",
+			"http.status":      "404",
+			tagProtocolVersion: tagThriftV1,
+		},
+		Metrics: map[string]float64{"http.status": 404},
+
+		Timings: proxy.Timings{
+			CS: proxy.Timestamp(1560276970 * time.Second),
+			CR: proxy.Timestamp(1560276971 * time.Second),
+		},
+	}))
+}
+
+func TestParseThriftV1_Invalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := encodedThriftV1()
+
+	// truncated input must not panic
+	for idx := 0; idx < len(encoded); idx++ {
+		_, err := ParseThriftV1(bytes.NewReader(encoded[:idx]))
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+func BenchmarkParseThriftV1(b *testing.B) {
+	data := encodedThriftV1()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var sum proxy.Id
+		for pb.Next() {
+			spans, _ := ParseThriftV1(bytes.NewReader(data))
+			for _, span := range spans {
+				sum += span.Id
+			}
+		}
+	})
+}
+
+// A minimal writer for the thrift binary protocol to generate test input.
+type thriftBinaryWriter struct {
+	bytes.Buffer
+}
+
+func (w *thriftBinaryWriter) Field(fieldType byte, fieldId int16) {
+	w.WriteByte(fieldType)
+	_ = binary.Write(w, binary.BigEndian, fieldId)
+}
+
+func (w *thriftBinaryWriter) Stop() {
+	w.WriteByte(thriftTypeStop)
+}
+
+func (w *thriftBinaryWriter) List(elementType byte, size int) {
+	w.WriteByte(elementType)
+	_ = binary.Write(w, binary.BigEndian, int32(size))
+}
+
+func (w *thriftBinaryWriter) I16(value int16) {
+	_ = binary.Write(w, binary.BigEndian, value)
+}
+
+func (w *thriftBinaryWriter) I32(value int32) {
+	_ = binary.Write(w, binary.BigEndian, value)
+}
+
+func (w *thriftBinaryWriter) I64(value int64) {
+	_ = binary.Write(w, binary.BigEndian, value)
+}
+
+func (w *thriftBinaryWriter) Binary(value []byte) {
+	w.I32(int32(len(value)))
+	w.Write(value)
+}
+
+func (w *thriftBinaryWriter) String(value string) {
+	w.Binary([]byte(value))
+}
+
+func encodedThriftV1() []byte {
+	var w thriftBinaryWriter
+
+	endpoint := func(fieldId int16) {
+		w.Field(thriftTypeStruct, fieldId)
+		w.Field(thriftTypeI32, 1)
+		w.I32(0x7f000001)
+		w.Field(thriftTypeI16, 2)
+		w.I16(8080)
+		w.Field(thriftTypeString, 3)
+		w.String("my-service")
+		w.Stop()
+	}
+
+	annotation := func(timestamp int64, value string) {
+		w.Field(thriftTypeI64, 1)
+		w.I64(timestamp)
+		w.Field(thriftTypeString, 2)
+		w.String(value)
+		endpoint(3)
+		w.Stop()
+	}
+
+	binaryAnnotation := func(key string, annotationType int32, value []byte) {
+		w.Field(thriftTypeString, 1)
+		w.String(key)
+		w.Field(thriftTypeString, 2)
+		w.Binary(value)
+		w.Field(thriftTypeI32, 3)
+		w.I32(annotationType)
+		endpoint(4)
+		w.Stop()
+	}
+
+	w.List(thriftTypeStruct, 1)
+
+	w.Field(thriftTypeI64, 1)
+	w.I64(0xbeaf)
+	w.Field(thriftTypeString, 3)
+	w.String("span name")
+	w.Field(thriftTypeI64, 4)
+	w.I64(0xdead)
+	w.Field(thriftTypeI64, 5)
+	w.I64(0xbeaf)
+
+	w.Field(thriftTypeList, 6)
+	w.List(thriftTypeStruct, 2)
+	annotation(1560276970000000, "cs")
+	annotation(1560276971000000, "cr")
+
+	w.Field(thriftTypeList, 8)
+	w.List(thriftTypeStruct, 3)
+	binaryAnnotation("http.path", annotationTypeString, []byte("/my/path"))
+	binaryAnnotation("http.status", annotationTypeI16, []byte{0x01, 0x94})
+	binaryAnnotation("sampled", annotationTypeBool, []byte{1})
+
+	// an unknown field that must be skipped
+	w.Field(thriftTypeList, 42)
+	w.List(thriftTypeString, 1)
+	w.String("unknown")
+
+	w.Field(thriftTypeI64, 10)
+	w.I64(1560276900000000)
+	w.Field(thriftTypeI64, 11)
+	w.I64(50000)
+	w.Field(thriftTypeI64, 12)
+	w.I64(1)
+	w.Stop()
+
+	return w.Bytes()
+}
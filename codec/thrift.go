@@ -0,0 +1,311 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/flachnetz/dd-zipkin-proxy/cache"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+)
+
+// type ids of the thrift wire format.
+const (
+	thriftTypeStop   byte = 0
+	thriftTypeBool   byte = 2
+	thriftTypeByte   byte = 3
+	thriftTypeDouble byte = 4
+	thriftTypeI16    byte = 6
+	thriftTypeI32    byte = 8
+	thriftTypeI64    byte = 10
+	thriftTypeString byte = 11
+	thriftTypeStruct byte = 12
+	thriftTypeMap    byte = 13
+	thriftTypeSet    byte = 14
+	thriftTypeList   byte = 15
+)
+
+// maximum nesting of structs and containers we skip over before giving up.
+const thriftMaxSkipDepth = 64
+
+// Reads values from a thrift protocol. Field and element types are always
+// reported using the type ids of the binary protocol.
+type thriftReader interface {
+	ReadStructBegin()
+	ReadStructEnd()
+
+	// Reads the header of the next field. Returns thriftTypeStop if
+	// the end of the current struct was reached.
+	ReadFieldBegin() (byte, int16, error)
+
+	ReadListBegin() (byte, int, error)
+	ReadMapBegin() (byte, byte, int, error)
+
+	ReadBool() (bool, error)
+	ReadByte() (byte, error)
+	ReadI16() (int16, error)
+	ReadI32() (int32, error)
+	ReadI64() (int64, error)
+	ReadDouble() (float64, error)
+	ReadBinary() ([]byte, error)
+	ReadString() (string, error)
+}
+
+// Reads a struct and calls the callback for each field. The callback
+// must consume the value of the field, e.g. by calling skipThrift.
+func readThriftStruct(r thriftReader, callback func(fieldType byte, fieldId int16) error) error {
+	r.ReadStructBegin()
+
+	for {
+		fieldType, fieldId, err := r.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+
+		if fieldType == thriftTypeStop {
+			r.ReadStructEnd()
+			return nil
+		}
+
+		if err := callback(fieldType, fieldId); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads a list of structs and calls the callback for each element.
+func readThriftStructList(r thriftReader, callback func(r thriftReader) error) error {
+	elementType, size, err := r.ReadListBegin()
+	if err != nil {
+		return err
+	}
+
+	if elementType != thriftTypeStruct {
+		return errors.Errorf("expected list of structs, got list of type %d", elementType)
+	}
+
+	for idx := 0; idx < size; idx++ {
+		if err := callback(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Skips a value of the given type.
+func skipThrift(r thriftReader, valueType byte) error {
+	return skipThriftValue(r, valueType, 0)
+}
+
+func skipThriftValue(r thriftReader, valueType byte, depth int) error {
+	if depth > thriftMaxSkipDepth {
+		return errors.New("thrift value nested too deep")
+	}
+
+	var err error
+
+	switch valueType {
+	case thriftTypeBool:
+		_, err = r.ReadBool()
+
+	case thriftTypeByte:
+		_, err = r.ReadByte()
+
+	case thriftTypeI16:
+		_, err = r.ReadI16()
+
+	case thriftTypeI32:
+		_, err = r.ReadI32()
+
+	case thriftTypeI64:
+		_, err = r.ReadI64()
+
+	case thriftTypeDouble:
+		_, err = r.ReadDouble()
+
+	case thriftTypeString:
+		_, err = r.ReadBinary()
+
+	case thriftTypeStruct:
+		err = readThriftStruct(r, func(fieldType byte, fieldId int16) error {
+			return skipThriftValue(r, fieldType, depth+1)
+		})
+
+	case thriftTypeMap:
+		keyType, valueType, size, err := r.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+
+		for idx := 0; idx < size; idx++ {
+			if err := skipThriftValue(r, keyType, depth+1); err != nil {
+				return err
+			}
+
+			if err := skipThriftValue(r, valueType, depth+1); err != nil {
+				return err
+			}
+		}
+
+	case thriftTypeSet, thriftTypeList:
+		elementType, size, err := r.ReadListBegin()
+		if err != nil {
+			return err
+		}
+
+		for idx := 0; idx < size; idx++ {
+			if err := skipThriftValue(r, elementType, depth+1); err != nil {
+				return err
+			}
+		}
+
+	default:
+		err = errors.Errorf("unknown thrift type %d", valueType)
+	}
+
+	return err
+}
+
+// Reads values encoded with the thrift binary protocol from an in memory buffer.
+// Binary values returned by the reader share memory with the buffer.
+type thriftBinaryReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftBinaryReader) remaining() int {
+	return len(r.buf) - r.pos
+}
+
+func (r *thriftBinaryReader) next(n int) ([]byte, error) {
+	if n < 0 || n > r.remaining() {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	value := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return value, nil
+}
+
+func (r *thriftBinaryReader) ReadByte() (byte, error) {
+	value, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return value[0], nil
+}
+
+func (r *thriftBinaryReader) ReadBool() (bool, error) {
+	value, err := r.ReadByte()
+	return value != 0, err
+}
+
+func (r *thriftBinaryReader) ReadI16() (int16, error) {
+	value, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+
+	return int16(binary.BigEndian.Uint16(value)), nil
+}
+
+func (r *thriftBinaryReader) ReadI32() (int32, error) {
+	value, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(binary.BigEndian.Uint32(value)), nil
+}
+
+func (r *thriftBinaryReader) ReadI64() (int64, error) {
+	value, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+func (r *thriftBinaryReader) ReadDouble() (float64, error) {
+	value, err := r.ReadI64()
+	return math.Float64frombits(uint64(value)), err
+}
+
+func (r *thriftBinaryReader) ReadBinary() ([]byte, error) {
+	length, err := r.ReadI32()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.next(int(length))
+}
+
+func (r *thriftBinaryReader) ReadString() (string, error) {
+	value, err := r.ReadBinary()
+	if err != nil {
+		return "", err
+	}
+
+	return cache.StringForByteSliceCopy(value), nil
+}
+
+func (r *thriftBinaryReader) ReadStructBegin() {
+}
+
+func (r *thriftBinaryReader) ReadStructEnd() {
+}
+
+func (r *thriftBinaryReader) ReadFieldBegin() (byte, int16, error) {
+	fieldType, err := r.ReadByte()
+	if err != nil || fieldType == thriftTypeStop {
+		return fieldType, 0, err
+	}
+
+	fieldId, err := r.ReadI16()
+	return fieldType, fieldId, err
+}
+
+func (r *thriftBinaryReader) ReadListBegin() (byte, int, error) {
+	elementType, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := r.ReadI32()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// each element needs at least one byte, so this is a cheap check
+	// to not allocate huge slices on broken input.
+	if size < 0 || int(size) > r.remaining() {
+		return 0, 0, errors.Errorf("invalid thrift list size %d", size)
+	}
+
+	return elementType, int(size), nil
+}
+
+func (r *thriftBinaryReader) ReadMapBegin() (byte, byte, int, error) {
+	keyType, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	valueType, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	size, err := r.ReadI32()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if size < 0 || int(size) > r.remaining() {
+		return 0, 0, 0, errors.Errorf("invalid thrift map size %d", size)
+	}
+
+	return keyType, valueType, int(size), nil
+}
@@ -1,7 +1,10 @@
 package codec
 
 import (
+	"encoding/binary"
 	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"net"
+	"strconv"
 )
 
 type Id = proxy.Id
@@ -9,5 +12,130 @@ type Id = proxy.Id
 var tagJsonV1 = "json v1"
 var tagJsonV2 = "json v2"
 var tagJaeger = "jaeger"
+var tagJaegerThrift = "jaeger thrift"
 var tagThriftV1 = "thrift v1"
+var tagProtoV2 = "proto v2"
+var tagOtlp = "otlp"
+var tagDatadog = "datadog"
 var tagProtocolVersion = "protocolVersion"
+
+// tag some zipkin clients use to send the B3 sampled flag
+const tagB3Sampled = "sampled"
+
+// tag of opentracing to force a sampling decision
+const tagSamplingPriority = "sampling.priority"
+
+// Formats an ipv4 address given as big endian integer. Returns an empty string for zero.
+func ipv4ToString(ip uint32) string {
+	if ip == 0 {
+		return ""
+	}
+
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], ip)
+	return net.IP(buf[:]).String()
+}
+
+// Formats an ipv6 address given as 16 bytes. Returns an empty string for invalid values.
+func ipv6ToString(ip []byte) string {
+	if len(ip) != net.IPv6len {
+		return ""
+	}
+
+	return net.IP(ip).String()
+}
+
+// integers up to this size can be represented exactly as float64
+const maxExactFloatInt = 1 << 53
+
+// The value of a tag as sent by the client. Numbers keep their type,
+// so that they can be sent to datadog as metrics. The text is set for all values.
+type tagValue struct {
+	Text     string
+	Number   float64
+	IsNumber bool
+}
+
+func textTagValue(text string) tagValue {
+	return tagValue{Text: text}
+}
+
+func floatTagValue(value float64) tagValue {
+	return tagValue{Text: strconv.FormatFloat(value, 'f', -1, 64), Number: value, IsNumber: true}
+}
+
+// Integers that can not be represented exactly as float64, e.g. ids, are kept as text.
+func intTagValue(value int64) tagValue {
+	if value > maxExactFloatInt || value < -maxExactFloatInt {
+		return textTagValue(strconv.FormatInt(value, 10))
+	}
+
+	return tagValue{Text: strconv.FormatInt(value, 10), Number: float64(value), IsNumber: true}
+}
+
+// Parses a number as sent by the client. The text is kept as sent.
+func parseNumberTagValue(text string) tagValue {
+	var value tagValue
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+		value = intTagValue(number)
+	} else if number, err := strconv.ParseFloat(text, 64); err == nil {
+		value = floatTagValue(number)
+	}
+
+	value.Text = text
+	return value
+}
+
+// Adds the value as tag to the span. Numbers are also added as metric.
+func addTagValue(span *proxy.Span, key string, value tagValue) {
+	span.AddTag(key, value.Text)
+
+	if value.IsNumber {
+		span.AddMetric(key, value.Number)
+	}
+}
+
+// Takes the B3 sampled flag some zipkin clients send as tag. Unknown values are kept as tag.
+func takeB3SampledTag(span *proxy.Span) {
+	switch span.Tags[tagB3Sampled] {
+	case "1", "true":
+		span.Sampling.SetSampled(true)
+
+	case "0", "false":
+		span.Sampling.SetSampled(false)
+
+	case "d":
+		span.Sampling.Debug = true
+
+	default:
+		return
+	}
+
+	delete(span.Tags, tagB3Sampled)
+}
+
+// Takes the opentracing sampling.priority tag. A priority above zero
+// asks to keep the trace, zero asks to drop it.
+func takeSamplingPriorityTag(span *proxy.Span) {
+	priority, ok := span.Metrics[tagSamplingPriority]
+	if ok {
+		delete(span.Metrics, tagSamplingPriority)
+		delete(span.Tags, tagSamplingPriority)
+	} else if text, isTag := span.Tags[tagSamplingPriority]; isTag {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return
+		}
+
+		priority = value
+		delete(span.Tags, tagSamplingPriority)
+	} else {
+		return
+	}
+
+	if priority > 0 {
+		span.Sampling.SetPriority(proxy.PriorityUserKeep)
+	} else {
+		span.Sampling.SetPriority(proxy.PriorityUserReject)
+	}
+}
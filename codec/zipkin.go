@@ -0,0 +1,75 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// Encodings of a list of spans as published by zipkin reporters.
+const (
+	EncodingAuto   = "auto"
+	EncodingJsonV1 = "json-v1"
+	EncodingJsonV2 = "json-v2"
+	EncodingThrift = "thrift"
+	EncodingProto  = "proto"
+)
+
+var zipkinParsers = map[string]func(io.Reader) ([]proxy.Span, error){
+	EncodingJsonV1: ParseJsonV1,
+	EncodingJsonV2: ParseJsonV2,
+	EncodingThrift: ParseThriftV1,
+	EncodingProto:  ParseProtoV2,
+}
+
+// Detects the encoding of a message containing a list of zipkin spans,
+// similar to what the zipkin collector does.
+func DetectZipkinEncoding(payload []byte) (string, error) {
+	if len(payload) == 0 {
+		return "", errors.New("empty message")
+	}
+
+	// do not trim binary payloads, a protobuf message might start with a newline byte
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		// v2 spans have no binary annotations and no endpoint in their annotations
+		if bytes.Contains(payload, []byte(`"binaryAnnotations"`)) || bytes.Contains(payload, []byte(`"endpoint"`)) {
+			return EncodingJsonV1, nil
+		}
+
+		return EncodingJsonV2, nil
+
+	case payload[0] == thriftTypeStruct:
+		// a thrift list of span structs
+		return EncodingThrift, nil
+
+	case payload[0] == 0x0a && len(payload) > 1 && payload[1] != 0:
+		// the first field of a ListOfSpans message. A single thrift span would
+		// also start with 0x0a, followed by the high byte of the field id.
+		return EncodingProto, nil
+
+	default:
+		return "", errors.Errorf("unknown encoding of message starting with %x", payload[0])
+	}
+}
+
+// Parses a message containing a list of zipkin spans in the given encoding.
+// Use EncodingAuto to detect the encoding of the message.
+func ParseZipkin(encoding string, payload []byte) ([]proxy.Span, error) {
+	if encoding == EncodingAuto {
+		var err error
+		if encoding, err = DetectZipkinEncoding(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	parser, ok := zipkinParsers[encoding]
+	if !ok {
+		return nil, errors.Errorf("unsupported encoding %q", encoding)
+	}
+
+	return parser(bytes.NewReader(payload))
+}
@@ -0,0 +1,44 @@
+package codec
+
+import (
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestDetectZipkinEncoding(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	detect := func(payload []byte) string {
+		encoding, err := DetectZipkinEncoding(payload)
+		g.Expect(err).ToNot(HaveOccurred())
+		return encoding
+	}
+
+	g.Expect(detect([]byte(encodedJsonV1))).To(Equal(EncodingJsonV1))
+	g.Expect(detect([]byte(encodedJsonV2))).To(Equal(EncodingJsonV2))
+	g.Expect(detect(encodedThriftV1())).To(Equal(EncodingThrift))
+	g.Expect(detect(encodedProtoV2())).To(Equal(EncodingProto))
+
+	_, err := DetectZipkinEncoding(nil)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = DetectZipkinEncoding([]byte("{}"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseZipkin(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, payload := range [][]byte{[]byte(encodedJsonV1), []byte(encodedJsonV2), encodedThriftV1(), encodedProtoV2()} {
+		spans, err := ParseZipkin(EncodingAuto, payload)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(spans).ToNot(BeEmpty())
+	}
+
+	// the configured encoding is used without detection
+	_, err := ParseZipkin(EncodingProto, []byte(encodedJsonV2))
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = ParseZipkin("avro", encodedProtoV2())
+	g.Expect(err).To(HaveOccurred())
+}
@@ -13,6 +13,9 @@ type Id = proxy.Id
 
 const maxSpans = 100_000
 
+// prefix for the tags of the client half of a shared span that differ from the server tags
+const tagPrefixClient = "client."
+
 var bufferConfigs = []struct {
 	MaxSpans   int
 	BufferTime time.Duration
@@ -32,12 +35,14 @@ var metricsTracesInflight metrics.Gauge
 var metricsTracesCorrected metrics.Meter
 var metricsTracesDiscarded metrics.Meter
 var metricsSpansMerged metrics.Meter
+var metricsSpansFollowsFrom metrics.Meter
 var metricsSpansDiscarded metrics.Meter
 var metricsSpansInflight metrics.Gauge
 var metricsReceivedBlacklistedSpan metrics.Meter
 
 func init() {
 	metricsSpansMerged = metrics.GetOrRegisterMeter("spans.merged", nil)
+	metricsSpansFollowsFrom = metrics.GetOrRegisterMeter("spans.followsfrom", nil)
 	metricsSpansDiscarded = metrics.GetOrRegisterMeter("spans.discarded", nil)
 	metricsTracesCorrected = metrics.GetOrRegisterMeter("traces.corrected", nil)
 	metricsTracesFinished = metrics.GetOrRegisterMeter("traces.finished", nil)
@@ -56,7 +61,8 @@ func init() {
 type none struct{}
 
 type tree struct {
-	traceId Id
+	// the full 128 bit id of the trace
+	traceId proxy.TraceId
 
 	spans SpanSlice
 
@@ -65,7 +71,7 @@ type tree struct {
 	nodeCount uint16
 }
 
-func newTree(traceId Id) *tree {
+func newTree(traceId proxy.TraceId) *tree {
 	now := time.Now()
 	return &tree{
 		traceId: traceId,
@@ -93,7 +99,11 @@ func (tree *tree) AddSpan(newSpan proxy.Span) {
 		span.Parent = newSpan.Parent
 	}
 
-	mergeSpansInPlace(span, newSpan)
+	if span.Shared || newSpan.Shared {
+		mergeSharedSpansInPlace(span, newSpan)
+	} else {
+		mergeSpansInPlace(span, newSpan)
+	}
 }
 
 func (tree *tree) ByParent() map[Id][]*proxy.Span {
@@ -113,7 +123,7 @@ func (tree *tree) GetSpan(spanId Id) *proxy.Span {
 
 // gets the root of this tree, or nil, if no root exists.
 func (tree *tree) Root() *proxy.Span {
-	return tree.spans.GetSpanRef(tree.traceId)
+	return tree.spans.GetSpanRef(tree.traceId.Low)
 }
 
 // gets the children of the given span in this tree.
@@ -155,14 +165,66 @@ func (tree *tree) Roots() []*proxy.Span {
 	return candidates
 }
 
-func ErrorCorrectSpans(inputCh <-chan proxy.Span, outputCh chan<- proxy.Trace) {
-	traces := make(map[Id]*tree)
+// Uses a FOLLOWS_FROM link as parent of root candidates, if the linked span is part
+// of this tree. Stops once only one root is left. Returns true if a span was attached.
+func (tree *tree) AttachFollowsFrom(roots []*proxy.Span) bool {
+	remaining := len(roots)
+
+	for _, span := range roots {
+		if remaining <= 1 {
+			break
+		}
+
+		for _, link := range span.Links {
+			if !link.FollowsFrom || link.Trace != span.TraceId() || tree.IsAncestor(span.Id, link.Span) {
+				continue
+			}
+
+			if tree.GetSpan(link.Span) == nil {
+				continue
+			}
+
+			span.Parent = link.Span
+			remaining--
+
+			metricsSpansFollowsFrom.Mark(1)
+			break
+		}
+	}
+
+	return remaining < len(roots)
+}
+
+// Checks if the span with the given id is the other span or one of its parents.
+func (tree *tree) IsAncestor(spanId, otherId Id) bool {
+	// limit the depth, the parents might contain a cycle
+	for depth := 0; depth <= int(tree.nodeCount); depth++ {
+		if otherId == spanId {
+			return true
+		}
+
+		span := tree.GetSpan(otherId)
+		if span == nil || span.IsRoot() {
+			return false
+		}
+
+		otherId = span.Parent
+	}
+
+	return false
+}
+
+// Groups the spans into traces and corrects their timings. Clock skew
+// between services is corrected as configured in the SkewCorrection.
+func ErrorCorrectSpans(inputCh <-chan proxy.Span, outputCh chan<- proxy.Trace, skew *SkewCorrection) {
+	// traces are grouped by the full 128 bit trace id
+	traces := make(map[proxy.TraceId]*tree)
 
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
 	// blacklisted trace ids.
-	blacklistedTraces := map[Id]none{}
+	blacklistedTraces := map[proxy.TraceId]none{}
 
 	for {
 		select {
@@ -177,27 +239,29 @@ func ErrorCorrectSpans(inputCh <-chan proxy.Span, outputCh chan<- proxy.Trace) {
 				continue
 			}
 
+			traceId := span.TraceId()
+
 			// check if trace is in black list
-			if _, ok := blacklistedTraces[span.Trace]; ok {
+			if _, ok := blacklistedTraces[traceId]; ok {
 				metricsReceivedBlacklistedSpan.Mark(1)
 				continue
 			}
 
-			trace := traces[span.Trace]
+			trace := traces[traceId]
 			if trace == nil {
-				trace = newTree(span.Trace)
-				traces[span.Trace] = trace
+				trace = newTree(traceId)
+				traces[traceId] = trace
 			}
 
 			trace.AddSpan(span)
 
 		case <-ticker.C:
-			finishTraces(traces, blacklistedTraces, outputCh)
+			finishTraces(traces, blacklistedTraces, outputCh, skew)
 		}
 	}
 }
 
-func finishTraces(traces map[Id]*tree, blacklist map[Id]none, outputCh chan<- proxy.Trace) {
+func finishTraces(traces map[proxy.TraceId]*tree, blacklist map[proxy.TraceId]none, outputCh chan<- proxy.Trace, skew *SkewCorrection) {
 	var spanCount int
 
 	// count the number of spans that are currently in the system.
@@ -256,6 +320,10 @@ func finishTraces(traces map[Id]*tree, blacklist map[Id]none, outputCh chan<- pr
 
 		// if we have a root, try do error correction
 		roots := trace.Roots()
+		if len(roots) > 1 && trace.AttachFollowsFrom(roots) {
+			roots = trace.Roots()
+		}
+
 		if len(roots) > 1 && allTheSameParent(roots) {
 			// add a fake root to the span and look for a new root span
 			trace.AddSpan(createFakeRoot(roots))
@@ -274,7 +342,7 @@ func finishTraces(traces map[Id]*tree, blacklist map[Id]none, outputCh chan<- pr
 			continue
 		}
 
-		correctTreeTimings(trace, roots[0], nil, 0)
+		correctTreeTimings(trace, skew, roots[0], nil, 0)
 
 		metricsTracesCorrected.Mark(1)
 
@@ -305,6 +373,16 @@ func finishTraces(traces map[Id]*tree, blacklist map[Id]none, outputCh chan<- pr
 	}
 }
 
+func hasLink(span *proxy.Span, link proxy.Link) bool {
+	for _, existing := range span.Links {
+		if existing.Trace == link.Trace && existing.Span == link.Span {
+			return true
+		}
+	}
+
+	return false
+}
+
 func createFakeRoot(spans []*proxy.Span) proxy.Span {
 	firstTimestamp := spans[0].Timestamp
 	lastTimestamp := spans[0].Timestamp.Add(spans[0].Duration)
@@ -321,6 +399,7 @@ func createFakeRoot(spans []*proxy.Span) proxy.Span {
 	}
 
 	root := proxy.NewRootSpan("fake-root", spans[0].Trace, spans[0].Parent)
+	root.TraceHigh = spans[0].TraceHigh
 
 	root.Service = "fake-root"
 	root.Timestamp = firstTimestamp
@@ -377,19 +456,19 @@ func debugPrintTrace(trace *tree) {
 	}
 
 	for idx, root := range roots {
-		log.Warnf("Trace %s, root #%d", roots[0].Trace, idx)
+		log.Warnf("Trace %s, root #%d", roots[0].TraceId(), idx)
 		printNode(root, 0)
 	}
 
 	log.Warnln()
 }
 
-func discardSuspiciousTraces(trees map[Id]*tree, maxSpans int) {
+func discardSuspiciousTraces(trees map[proxy.TraceId]*tree, maxSpans int) {
 	var spanCount int
 
 	type trace struct {
 		*tree
-		id Id
+		id proxy.TraceId
 	}
 
 	traces := make([]trace, 0, len(trees))
@@ -432,9 +511,13 @@ func discardSuspiciousTraces(trees map[Id]*tree, maxSpans int) {
 	log.Warnf("Too many spans, discarded %d trace with %d spans", discardTraceCount, discardSpanCount)
 }
 
-func correctTreeTimings(tree *tree, node *proxy.Span, parent *proxy.Span, offset time.Duration) {
+func correctTreeTimings(tree *tree, skew *SkewCorrection, node *proxy.Span, parent *proxy.Span, offset time.Duration) {
 	if offset != 0 {
 		node.Timestamp += proxy.Timestamp(offset)
+
+		for idx := range node.Events {
+			node.Events[idx].Timestamp += proxy.Timestamp(offset)
+		}
 	}
 
 	clientSent := node.Timings.CS
@@ -442,19 +525,17 @@ func correctTreeTimings(tree *tree, node *proxy.Span, parent *proxy.Span, offset
 	serverSent := node.Timings.SS
 	serverRecv := node.Timings.SR
 
-	//        _________________________
-	//       |_cs________|_____________| cr
-	//                   |
-	//                   |--| <-  (cr+cs)/2 - (ss+sr)/2. If the server is right of the client, this difference is
-	//                      |     negative. We need to add the difference to the server time
-	//                      |     to get the corrected time in "client time" under the idea that the
-	//                      |     server span is centered in respect to the client span.
-	//            __________|__________
-	//           |_sr_______|__________| ss
-
 	if clientRecv != 0 && clientSent != 0 && serverRecv != 0 && serverSent != 0 {
-		// This is the time difference between client & server as described above
-		screw := time.Duration((clientRecv+clientSent)/2 - (serverRecv+serverSent)/2)
+		// the client of a shared span is the service of the parent span
+		var pair ServicePair
+		if parent != nil {
+			pair.Client = parent.Service
+		}
+
+		pair.Server = node.Service
+
+		// This is the time difference between client & server, see SkewCorrector
+		screw := skew.For(pair).Skew(node.Timings)
 
 		if log.Level >= logrus.DebugLevel {
 			if screw < -25*time.Millisecond || screw > 25*time.Millisecond {
@@ -469,8 +550,17 @@ func correctTreeTimings(tree *tree, node *proxy.Span, parent *proxy.Span, offset
 		// update the duration using the client info.
 		node.Duration = time.Duration(clientRecv - clientSent)
 
+		// events of the server are moved like its child spans
+		for idx := range node.Events {
+			if node.Events[idx].Server {
+				node.Events[idx].Timestamp += proxy.Timestamp(screw)
+			}
+		}
+
 		// update offset for child spans
 		offset += screw
+
+		skew.Observe(pair, measureSkew(node.Timings))
 	}
 
 	if parent != nil && node.Timestamp.ToMillis() < 1577836800_000 {
@@ -482,20 +572,150 @@ func correctTreeTimings(tree *tree, node *proxy.Span, parent *proxy.Span, offset
 		}
 	}
 
-	for _, child := range tree.ChildrenOf(node.Id) {
-		correctTreeTimings(tree, child, node, offset)
+	children := tree.ChildrenOf(node.Id)
+
+	if len(children) == 1 && isRemoteCall(node, children[0]) {
+		// With separate ids for client and server, the server span is the only child of
+		// the client span. Both spans are compared in their own, uncorrected time.
+		server := children[0]
+
+		clientSent := node.Timestamp - proxy.Timestamp(offset)
+
+		timings := proxy.Timings{
+			CS: clientSent,
+			CR: clientSent.Add(node.Duration),
+			SR: server.Timestamp,
+			SS: server.Timestamp.Add(server.Duration),
+		}
+
+		pair := ServicePair{Client: node.Service, Server: server.Service}
+		screw := skew.For(pair).Skew(timings)
+
+		if log.Level >= logrus.DebugLevel {
+			if screw < -25*time.Millisecond || screw > 25*time.Millisecond {
+				log.Debugf("Found time screw of %s between '%s' and '%s'", screw, node.Name, server.Name)
+			}
+		}
+
+		skew.Observe(pair, measureSkew(timings))
+
+		correctTreeTimings(tree, skew, server, node, offset+screw)
+		return
+	}
+
+	for _, child := range children {
+		childOffset := offset
+
+		// without the timings of both sides we use what we learned from other traces
+		if isCallWithoutTimings(node, child) {
+			pair := ServicePair{Client: node.Service, Server: child.Service}
+
+			// unless the correction is disabled for the services
+			if _, disabled := skew.For(pair).(noopSkewCorrector); !disabled {
+				if learned, ok := skew.Estimate(pair); ok {
+					childOffset += learned
+				}
+			}
+		}
+
+		correctTreeTimings(tree, skew, child, node, childOffset)
+	}
+}
+
+// Checks if the child span is called by the parent span in another service, but the timings
+// to correct the skew between them are missing, e.g. because the client span was not reported.
+func isCallWithoutTimings(parent, child *proxy.Span) bool {
+	if parent.Service == "" || child.Service == "" || parent.Service == child.Service {
+		return false
+	}
+
+	// shared spans are corrected using their own timings
+	if child.Timings.CS.IsValid() && child.Timings.SR.IsValid() {
+		return false
+	}
+
+	// a consumer may handle a message much later
+	switch {
+	case parent.Kind == proxy.KindProducer || parent.Kind == proxy.KindConsumer:
+		return false
+
+	case child.Kind == proxy.KindProducer || child.Kind == proxy.KindConsumer:
+		return false
+
+	default:
+		return true
+	}
+}
+
+// Checks if the server span handles the call of the client span in another service.
+// Messaging spans are not included, a consumer may handle a message much later.
+func isRemoteCall(client, server *proxy.Span) bool {
+	if client.Duration <= 0 || server.Duration <= 0 || client.Service == server.Service {
+		return false
+	}
+
+	// a span with timings of both sides was already corrected
+	if client.Timings.SR.IsValid() || client.Timings.SS.IsValid() {
+		return false
+	}
+
+	clientKind, serverKind := client.Kind, server.Kind
+
+	// spans from older versions might only have timings
+	if clientKind == proxy.KindUnspecified && (client.Timings.CS.IsValid() || client.Timings.CR.IsValid()) {
+		clientKind = proxy.KindClient
+	}
+
+	if serverKind == proxy.KindUnspecified && !server.Timings.CS.IsValid() && !server.Timings.CR.IsValid() &&
+		(server.Timings.SR.IsValid() || server.Timings.SS.IsValid()) {
+		serverKind = proxy.KindServer
+	}
+
+	return clientKind == proxy.KindClient && serverKind == proxy.KindServer
+}
+
+// Checks if the span is the receiving side of a call. Spans without
+// a kind, e.g. from older versions, are checked by their timings.
+func isServerSpan(span *proxy.Span) bool {
+	if span.Shared {
+		return true
+	}
+
+	switch span.Kind {
+	case proxy.KindServer, proxy.KindConsumer:
+		return true
+
+	case proxy.KindUnspecified:
+		return span.Timings.SR.IsValid() || span.Timings.SS.IsValid()
+
+	default:
+		return false
 	}
 }
 
 func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
-	newSpanIsServer := newSpan.Timings.SR.IsValid() || newSpan.Timings.SS.IsValid()
+	newEvents := newSpan.Events
+
+	if isServerSpan(&newSpan) {
+		newEvents = serverEvents(newEvents)
 
-	if newSpanIsServer {
 		// prefer values from newSpan (server span)
 		if newSpan.Service != "" {
 			spanToUpdate.Service = newSpan.Service
 		}
 
+		if newSpan.Kind != proxy.KindUnspecified {
+			spanToUpdate.Kind = newSpan.Kind
+		}
+
+		if newSpan.LocalEndpoint != nil {
+			spanToUpdate.LocalEndpoint = newSpan.LocalEndpoint
+		}
+
+		if newSpan.RemoteEndpoint != nil {
+			spanToUpdate.RemoteEndpoint = newSpan.RemoteEndpoint
+		}
+
 		if newSpan.Name != "" {
 			spanToUpdate.Name = newSpan.Name
 		}
@@ -505,6 +725,10 @@ func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
 			spanToUpdate.AddTag(key, value)
 		}
 
+		for key, value := range newSpan.Metrics {
+			spanToUpdate.AddMetric(key, value)
+		}
+
 		if newSpan.Timings.SR.IsValid() {
 			spanToUpdate.Timings.SR = newSpan.Timings.SR
 		}
@@ -514,6 +738,11 @@ func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
 		}
 
 	} else {
+		// the span we merge into is the server half, if it has no client timings yet
+		if isServerSpan(spanToUpdate) && !spanToUpdate.Timings.CS.IsValid() && !spanToUpdate.Timings.CR.IsValid() {
+			spanToUpdate.Events = serverEvents(spanToUpdate.Events)
+		}
+
 		// merge tags, prefer the ones from the spanToUpdate (client)
 		if spanToUpdate.Service == "" {
 			spanToUpdate.Service = newSpan.Service
@@ -523,8 +752,21 @@ func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
 			spanToUpdate.Name = newSpan.Name
 		}
 
+		if spanToUpdate.Kind == proxy.KindUnspecified {
+			spanToUpdate.Kind = newSpan.Kind
+		}
+
+		if spanToUpdate.LocalEndpoint == nil {
+			spanToUpdate.LocalEndpoint = newSpan.LocalEndpoint
+		}
+
+		if spanToUpdate.RemoteEndpoint == nil {
+			spanToUpdate.RemoteEndpoint = newSpan.RemoteEndpoint
+		}
+
 		// backup client values, so we can overwrite server values from newSpan later
 		clientTags := spanToUpdate.Tags
+		clientMetrics := spanToUpdate.Metrics
 
 		// merge tags
 		spanToUpdate.Tags = nil
@@ -536,6 +778,15 @@ func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
 			spanToUpdate.AddTag(key, value)
 		}
 
+		spanToUpdate.Metrics = nil
+		for key, value := range newSpan.Metrics {
+			spanToUpdate.AddMetric(key, value)
+		}
+
+		for key, value := range clientMetrics {
+			spanToUpdate.AddMetric(key, value)
+		}
+
 		if newSpan.Timings.CS.IsValid() {
 			spanToUpdate.Timings.CS = newSpan.Timings.CS
 		}
@@ -545,9 +796,75 @@ func mergeSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
 		}
 	}
 
+	spanToUpdate.Sampling = spanToUpdate.Sampling.Merge(newSpan.Sampling)
+
+	// keep the events of both sides
+	spanToUpdate.Events = append(spanToUpdate.Events, newEvents...)
+
+	// both sides usually report the same links
+	for _, link := range newSpan.Links {
+		if !hasLink(spanToUpdate, link) {
+			spanToUpdate.AddLink(link)
+		}
+	}
+
 	metricsSpansMerged.Mark(1)
 }
 
+// Returns a copy of the events marked as events of the server.
+func serverEvents(events []proxy.Event) []proxy.Event {
+	if len(events) == 0 {
+		return events
+	}
+
+	result := make([]proxy.Event, len(events))
+	for idx, event := range events {
+		event.Server = true
+		result[idx] = event
+	}
+
+	return result
+}
+
+// Merges the client and server half of a span with a shared id. The server half is
+// the one marked as shared, independent of the order in which both halves arrive.
+// Client tags that differ from the server tags are kept with a "client." prefix.
+func mergeSharedSpansInPlace(spanToUpdate *proxy.Span, newSpan proxy.Span) {
+	client, server := *spanToUpdate, newSpan
+	if spanToUpdate.Shared && !newSpan.Shared {
+		client, server = newSpan, *spanToUpdate
+	}
+
+	merged := client
+	merged.Tags = nil
+	merged.Metrics = nil
+
+	for key, value := range client.Tags {
+		merged.AddTag(key, value)
+
+		if serverValue, ok := server.Tags[key]; ok && serverValue != value {
+			merged.AddTag(tagPrefixClient+key, value)
+		}
+	}
+
+	for key, value := range client.Metrics {
+		merged.AddMetric(key, value)
+
+		if serverValue, ok := server.Metrics[key]; ok && serverValue != value {
+			merged.AddMetric(tagPrefixClient+key, value)
+		}
+	}
+
+	mergeSpansInPlace(&merged, server)
+
+	if merged.Parent.IsUnknown() {
+		merged.Parent = server.Parent
+	}
+
+	merged.Shared = true
+	*spanToUpdate = merged
+}
+
 type SpanSlice proxy.Trace
 
 func (spans SpanSlice) GetSpanRef(spanId Id) *proxy.Span {
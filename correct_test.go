@@ -15,7 +15,7 @@ func TestTree(t *testing.T) {
 	firstSpan := proxy.Span{Id: 1}
 	secondSpan := proxy.Span{Id: 2, Parent: firstSpan.Id}
 
-	tree := newTree(1)
+	tree := newTree(proxy.TraceId{Low: 1})
 	tree.AddSpan(firstSpan)
 	tree.AddSpan(secondSpan)
 
@@ -27,6 +27,63 @@ func TestTree(t *testing.T) {
 	Expect(tree.Roots()[0]).To(Equal(&firstSpan))
 }
 
+func TestTree_AttachFollowsFrom(t *testing.T) {
+	RegisterTestingT(t)
+
+	followsFrom := func(spanId Id) []proxy.Link {
+		return []proxy.Link{{Trace: proxy.TraceId{Low: 1}, Span: spanId, FollowsFrom: true}}
+	}
+
+	tree := newTree(proxy.TraceId{Low: 1})
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 1, Parent: 1})
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 2, Parent: 1})
+
+	// async work of span 2 without a parent
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 3, Parent: 3, Links: followsFrom(2)})
+
+	// link to a span in an other trace
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 4, Parent: 4, Links: []proxy.Link{{Trace: proxy.TraceId{Low: 2}, Span: 2, FollowsFrom: true}}})
+
+	Expect(tree.Roots()).To(HaveLen(3))
+	Expect(tree.AttachFollowsFrom(tree.Roots())).To(BeTrue())
+
+	Expect(tree.GetSpan(3).Parent).To(Equal(Id(2)))
+	Expect(tree.GetSpan(4).IsRoot()).To(BeTrue())
+	Expect(tree.Roots()).To(HaveLen(2))
+
+	// a link must not create a cycle
+	tree = newTree(proxy.TraceId{Low: 1})
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 1, Parent: 1, Links: followsFrom(2)})
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 2, Parent: 1})
+	tree.AddSpan(proxy.Span{Trace: 1, Id: 5, Parent: 5})
+
+	Expect(tree.AttachFollowsFrom(tree.Roots())).To(BeFalse())
+}
+
+func TestFinishTraces_TraceIdHigh(t *testing.T) {
+	RegisterTestingT(t)
+
+	outputCh := make(chan proxy.Trace, 2)
+
+	// two traces that only differ in the upper 64 bits
+	traces := map[proxy.TraceId]*tree{}
+	for _, high := range []proxy.Id{1, 2} {
+		span := proxy.Span{Trace: 1, TraceHigh: high, Id: 1, Parent: 1, Timestamp: proxy.Timestamp(time.Now().UnixNano())}
+
+		trace := newTree(span.TraceId())
+		trace.AddSpan(span)
+		trace.updated = time.Now().Add(-time.Minute)
+
+		traces[span.TraceId()] = trace
+	}
+
+	finishTraces(traces, map[proxy.TraceId]none{}, outputCh, nil)
+
+	Expect(outputCh).To(HaveLen(2))
+	Expect(<-outputCh).To(HaveLen(1))
+	Expect(<-outputCh).To(HaveLen(1))
+}
+
 func TestMergeSpansInPlace_Annotations(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -76,6 +133,170 @@ func TestMergeSpansInPlace_BinaryAnnotations_Reverse(t *testing.T) {
 	Expect(firstSpan.Tags["tag"]).To(Equal("b"))
 }
 
+func TestMergeSpansInPlace_Events(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{Kind: proxy.KindClient}
+	clientSpan.AddEvent(proxy.Event{Timestamp: 1, Name: "ws"})
+
+	serverSpan := proxy.Span{Kind: proxy.KindServer}
+	serverSpan.AddEvent(proxy.Event{Timestamp: 2, Name: "wr"})
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+
+	// events of the server are marked, so the skew can be corrected later
+	Expect(clientSpan.Events).To(Equal([]proxy.Event{
+		{Timestamp: 1, Name: "ws"},
+		{Timestamp: 2, Name: "wr", Server: true},
+	}))
+}
+
+func TestMergeSpansInPlace_Links(t *testing.T) {
+	RegisterTestingT(t)
+
+	link := proxy.Link{Trace: proxy.TraceId{Low: 1}, Span: 2, FollowsFrom: true}
+	otherLink := proxy.Link{Trace: proxy.TraceId{Low: 3}, Span: 4}
+
+	clientSpan := proxy.Span{Kind: proxy.KindClient, Links: []proxy.Link{link}}
+	serverSpan := proxy.Span{Kind: proxy.KindServer, Links: []proxy.Link{link, otherLink}}
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+
+	Expect(clientSpan.Links).To(Equal([]proxy.Link{link, otherLink}))
+}
+
+func TestMergeSpansInPlace_Sampling(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{Kind: proxy.KindClient}
+	clientSpan.Sampling.SetSampled(true)
+
+	// a user decision wins over an automatic one
+	serverSpan := proxy.Span{Kind: proxy.KindServer}
+	serverSpan.Sampling.SetPriority(proxy.PriorityUserReject)
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+	Expect(clientSpan.Sampling).To(Equal(proxy.Sampling{Decided: true, Priority: proxy.PriorityUserReject}))
+
+	// the debug flag of any side is kept
+	mergeSpansInPlace(&clientSpan, proxy.Span{Kind: proxy.KindServer, Sampling: proxy.Sampling{Debug: true}})
+	Expect(clientSpan.Sampling.Debug).To(BeTrue())
+
+	priority, decided := clientSpan.Sampling.EffectivePriority()
+	Expect(decided).To(BeTrue())
+	Expect(priority).To(Equal(proxy.PriorityUserKeep))
+}
+
+func TestTree_AddSharedSpan(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{Trace: 1, Id: 2, Parent: 1, Service: "client", Kind: proxy.KindClient}
+	clientSpan.Timings.CS = 100
+	clientSpan.Timings.CR = 400
+	clientSpan.AddTag("http.url", "http://server/path")
+	clientSpan.AddTag("component", "okhttp")
+
+	// the server half has no kind to identify it
+	serverSpan := proxy.Span{Trace: 1, Id: 2, Parent: 1, Service: "server", Shared: true}
+	serverSpan.Timings.SR = 200
+	serverSpan.Timings.SS = 300
+	serverSpan.AddTag("component", "jetty")
+	serverSpan.AddTag("http.status_code", "200")
+
+	expected := []string{"server", "jetty", "okhttp", "http://server/path", "200"}
+
+	// the result does not depend on the order in which the halves arrive
+	for _, spans := range [][]proxy.Span{{clientSpan, serverSpan}, {serverSpan, clientSpan}} {
+		tree := newTree(proxy.TraceId{Low: 1})
+		tree.AddSpan(spans[0])
+		tree.AddSpan(spans[1])
+
+		span := tree.GetSpan(2)
+		Expect(span.Shared).To(BeTrue())
+		Expect(span.Timings.CS).To(Equal(proxy.Timestamp(100)))
+		Expect(span.Timings.CR).To(Equal(proxy.Timestamp(400)))
+		Expect(span.Timings.SR).To(Equal(proxy.Timestamp(200)))
+		Expect(span.Timings.SS).To(Equal(proxy.Timestamp(300)))
+
+		Expect([]string{
+			span.Service,
+			span.Tags["component"],
+			span.Tags["client.component"],
+			span.Tags["http.url"],
+			span.Tags["http.status_code"],
+		}).To(Equal(expected))
+
+		Expect(span.Tags).ToNot(HaveKey("client.http.url"))
+	}
+
+	// the input spans are not modified
+	Expect(clientSpan.Tags).To(HaveLen(2))
+	Expect(serverSpan.Tags).To(HaveLen(2))
+}
+
+func TestMergeSpansInPlace_Kind(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{Service: "client", Kind: proxy.KindClient}
+	clientSpan.AddTag("tag", "a")
+
+	// the kind is enough to identify the server span, even without timings
+	serverSpan := proxy.Span{Service: "server", Kind: proxy.KindServer}
+	serverSpan.AddTag("tag", "b")
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+
+	Expect(clientSpan.Service).To(Equal("server"))
+	Expect(clientSpan.Kind).To(Equal(proxy.KindServer))
+	Expect(clientSpan.Tags["tag"]).To(Equal("b"))
+
+	// a producer span with server timings is still not the server side
+	consumerSpan := proxy.Span{Service: "consumer", Kind: proxy.KindConsumer}
+
+	producerSpan := proxy.Span{Service: "producer", Kind: proxy.KindProducer}
+	producerSpan.AddTiming("sr", 1)
+
+	mergeSpansInPlace(&consumerSpan, producerSpan)
+
+	Expect(consumerSpan.Service).To(Equal("consumer"))
+	Expect(consumerSpan.Kind).To(Equal(proxy.KindConsumer))
+}
+
+func TestMergeSpansInPlace_Metrics(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{Kind: proxy.KindClient}
+	clientSpan.AddMetric("retries", 1)
+	clientSpan.AddMetric("db.rows", 1)
+
+	serverSpan := proxy.Span{Kind: proxy.KindServer}
+	serverSpan.AddMetric("db.rows", 42)
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+
+	Expect(clientSpan.Metrics).To(Equal(map[string]float64{"retries": 1, "db.rows": 42}))
+}
+
+func TestMergeSpansInPlace_Endpoints(t *testing.T) {
+	RegisterTestingT(t)
+
+	clientSpan := proxy.Span{
+		Kind:           proxy.KindClient,
+		LocalEndpoint:  &proxy.Endpoint{ServiceName: "client"},
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "server", Port: 8080},
+	}
+
+	serverSpan := proxy.Span{
+		Kind:          proxy.KindServer,
+		LocalEndpoint: &proxy.Endpoint{ServiceName: "server", IPv4: "10.0.0.1"},
+	}
+
+	mergeSpansInPlace(&clientSpan, serverSpan)
+
+	Expect(clientSpan.LocalEndpoint).To(Equal(&proxy.Endpoint{ServiceName: "server", IPv4: "10.0.0.1"}))
+	Expect(clientSpan.RemoteEndpoint).To(Equal(&proxy.Endpoint{ServiceName: "server", Port: 8080}))
+}
+
 func TestCorrectTimings(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -83,10 +304,13 @@ func TestCorrectTimings(t *testing.T) {
 		indices := rand.Perm(4)
 		baseOffset := proxy.Timestamp(rand.Int31n(100000))
 
+		// timestamps before 2020 are treated as broken
+		start := proxy.Timestamp(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+
 		scale := proxy.Timestamp(1 * time.Millisecond)
-		client, sharedClient, sharedServer, server := threeSpans(100*scale, 200*scale, 1110*scale, 1190*scale)
+		client, sharedClient, sharedServer, server := threeSpans(start+100*scale, start+200*scale, start+1110*scale, start+1190*scale)
 
-		tree := newTree(client.Trace)
+		tree := newTree(client.TraceId())
 
 		if rand.Float32() < 0.5 {
 			sharedServer.Parent = 0
@@ -101,17 +325,148 @@ func TestCorrectTimings(t *testing.T) {
 		logrus.SetLevel(logrus.DebugLevel)
 		debugPrintTrace(tree)
 
-		correctTreeTimings(tree, tree.Root(), nil, time.Duration(baseOffset))
+		correctTreeTimings(tree, nil, tree.Root(), nil, time.Duration(baseOffset))
 
 		clientSpan := tree.GetSpan(client.Id)
-		Expect(clientSpan.Timestamp).To(BeEquivalentTo(proxy.Timestamp(baseOffset + 100*scale)))
+		Expect(clientSpan.Timestamp).To(BeEquivalentTo(proxy.Timestamp(start + baseOffset + 100*scale)))
 
 		serverSpan := tree.GetSpan(server.Id)
-		Expect(serverSpan.Timestamp).To(BeEquivalentTo(proxy.Timestamp(baseOffset + 110*scale)))
+		Expect(serverSpan.Timestamp).To(BeEquivalentTo(proxy.Timestamp(start + baseOffset + 110*scale)))
 
 		shared := tree.GetSpan(sharedClient.Id)
-		Expect(shared.Timestamp).To(BeEquivalentTo(proxy.Timestamp(baseOffset + 100*scale)))
+		Expect(shared.Timestamp).To(BeEquivalentTo(proxy.Timestamp(start + baseOffset + 100*scale)))
+
+		// the events are moved with the side they belong to
+		Expect(shared.Events).To(ConsistOf(
+			proxy.Event{Timestamp: proxy.Timestamp(baseOffset) + start + 105*scale, Name: "ws"},
+			proxy.Event{Timestamp: proxy.Timestamp(baseOffset) + start + 115*scale, Name: "wr", Server: true},
+		))
+	}
+}
+
+func TestCorrectTimings_ClientServer(t *testing.T) {
+	RegisterTestingT(t)
+
+	start := proxy.Timestamp(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	ms := proxy.Timestamp(time.Millisecond)
+
+	// the clock of the server is one second ahead
+	root := proxy.Span{Trace: 1, Id: 1, Parent: 1, Service: "frontend", Timestamp: start, Duration: 300 * time.Millisecond}
+	client := proxy.Span{Trace: 1, Id: 2, Parent: 1, Service: "frontend", Kind: proxy.KindClient, Timestamp: start + 100*ms, Duration: 100 * time.Millisecond}
+	server := proxy.Span{Trace: 1, Id: 3, Parent: 2, Service: "backend", Kind: proxy.KindServer, Timestamp: start + 1110*ms, Duration: 80 * time.Millisecond}
+	query := proxy.Span{Trace: 1, Id: 4, Parent: 3, Service: "backend", Timestamp: start + 1120*ms, Duration: 10 * time.Millisecond}
+
+	tree := newTree(proxy.TraceId{Low: 1})
+	for _, span := range []proxy.Span{root, client, server, query} {
+		tree.AddSpan(span)
+	}
+
+	correctTreeTimings(tree, nil, tree.Root(), nil, 0)
+
+	// the client is not moved, the server and its children are centered in the client span
+	Expect(tree.GetSpan(client.Id).Timestamp).To(Equal(start + 100*ms))
+	Expect(tree.GetSpan(server.Id).Timestamp).To(Equal(start + 110*ms))
+	Expect(tree.GetSpan(query.Id).Timestamp).To(Equal(start + 120*ms))
+
+	// nothing is moved if the correction is disabled for the services
+	correction, err := parseSkewCorrection("midpoint", []string{"frontend:backend=none"})
+	Expect(err).ToNot(HaveOccurred())
+
+	tree = newTree(proxy.TraceId{Low: 1})
+	for _, span := range []proxy.Span{root, client, server, query} {
+		tree.AddSpan(span)
+	}
+
+	correctTreeTimings(tree, correction, tree.Root(), nil, 0)
+
+	Expect(tree.GetSpan(server.Id).Timestamp).To(Equal(start + 1110*ms))
+	Expect(tree.GetSpan(query.Id).Timestamp).To(Equal(start + 1120*ms))
+}
+
+func TestCorrectTimings_LearnedSkew(t *testing.T) {
+	RegisterTestingT(t)
+
+	start := proxy.Timestamp(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	ms := proxy.Timestamp(time.Millisecond)
+
+	correction := &SkewCorrection{}
+
+	// learn the skew from a trace with client and server span
+	root := proxy.Span{Trace: 1, Id: 1, Parent: 1, Service: "frontend", Timestamp: start, Duration: 300 * time.Millisecond}
+	client := proxy.Span{Trace: 1, Id: 2, Parent: 1, Service: "frontend", Kind: proxy.KindClient, Timestamp: start + 100*ms, Duration: 100 * time.Millisecond}
+	server := proxy.Span{Trace: 1, Id: 3, Parent: 2, Service: "backend", Kind: proxy.KindServer, Timestamp: start + 1110*ms, Duration: 80 * time.Millisecond}
+
+	tree := newTree(proxy.TraceId{Low: 1})
+	for _, span := range []proxy.Span{root, client, server} {
+		tree.AddSpan(span)
+	}
+
+	correctTreeTimings(tree, correction, tree.Root(), nil, 0)
+
+	// the client span is missing in the second trace
+	server.Parent = 1
+
+	tree = newTree(proxy.TraceId{Low: 1})
+	for _, span := range []proxy.Span{root, server} {
+		tree.AddSpan(span)
 	}
+
+	correctTreeTimings(tree, correction, tree.Root(), nil, 0)
+
+	Expect(tree.GetSpan(server.Id).Timestamp).To(Equal(start + 110*ms))
+}
+
+func TestCorrectTimings_LearnedSkewWithoutCorrection(t *testing.T) {
+	RegisterTestingT(t)
+
+	start := proxy.Timestamp(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	ms := proxy.Timestamp(time.Millisecond)
+
+	for _, name := range []string{"none", "min-latency"} {
+		correction, err := parseSkewCorrection(name, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		client := proxy.Span{Trace: 1, Id: 1, Parent: 1, Service: "frontend", Kind: proxy.KindClient, Timestamp: start, Duration: 100 * time.Millisecond}
+		server := proxy.Span{Trace: 1, Id: 2, Parent: 1, Service: "backend", Kind: proxy.KindServer, Timestamp: start + 1010*ms, Duration: 80 * time.Millisecond}
+
+		tree := newTree(proxy.TraceId{Low: 1})
+		tree.AddSpan(client)
+		tree.AddSpan(server)
+
+		correctTreeTimings(tree, correction, tree.Root(), nil, 0)
+
+		// the measured skew is learned, independent of the correction
+		learned, ok := correction.Estimate(ServicePair{Client: "frontend", Server: "backend"})
+		Expect(ok).To(BeTrue())
+		Expect(learned).To(Equal(-1000 * time.Millisecond))
+	}
+}
+
+func TestIsRemoteCall(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := proxy.Span{Service: "frontend", Kind: proxy.KindClient, Duration: time.Second}
+	server := proxy.Span{Service: "backend", Kind: proxy.KindServer, Duration: time.Second}
+	Expect(isRemoteCall(&client, &server)).To(BeTrue())
+
+	// calls within the same service share the same clock
+	sameService := server
+	sameService.Service = "frontend"
+	Expect(isRemoteCall(&client, &sameService)).To(BeFalse())
+
+	// messages might be consumed much later
+	consumer := server
+	consumer.Kind = proxy.KindConsumer
+	Expect(isRemoteCall(&client, &consumer)).To(BeFalse())
+
+	// older spans without a kind are detected by their timings
+	oldClient := proxy.Span{Service: "frontend", Duration: time.Second, Timings: proxy.Timings{CS: 1, CR: 2}}
+	oldServer := proxy.Span{Service: "backend", Duration: time.Second, Timings: proxy.Timings{SR: 1, SS: 2}}
+	Expect(isRemoteCall(&oldClient, &oldServer)).To(BeTrue())
+
+	// a shared span with both sides was already corrected
+	shared := proxy.Span{Service: "frontend", Kind: proxy.KindClient, Duration: time.Second, Timings: proxy.Timings{CS: 1, CR: 4, SR: 2, SS: 3}}
+	Expect(isRemoteCall(&shared, &server)).To(BeFalse())
 }
 
 func threeSpans(cs, cr, sr, ss proxy.Timestamp) (proxy.Span, proxy.Span, proxy.Span, proxy.Span) {
@@ -124,10 +479,12 @@ func threeSpans(cs, cr, sr, ss proxy.Timestamp) (proxy.Span, proxy.Span, proxy.S
 	sharedClient := proxy.Span{Id: 2, Trace: 1, Parent: client.Id, Timestamp: cs, Duration: time.Duration(cr - cs)}
 	sharedClient.AddTiming("cs", cs)
 	sharedClient.AddTiming("cr", cr)
+	sharedClient.AddEvent(proxy.Event{Timestamp: cs + 5*proxy.Timestamp(time.Millisecond), Name: "ws"})
 
 	sharedServer := proxy.Span{Id: 2, Trace: 1, Parent: client.Id, Timestamp: sr + offset, Duration: time.Duration(ss - sr)}
 	sharedServer.AddTiming("sr", sr+offset)
 	sharedServer.AddTiming("ss", ss+offset)
+	sharedServer.AddEvent(proxy.Event{Timestamp: sr + offset + 5*proxy.Timestamp(time.Millisecond), Name: "wr"})
 
 	server := proxy.Span{Id: 3, Trace: 1, Parent: sharedServer.Id, Timestamp: sr + offset, Duration: time.Duration(ss - sr)}
 	server.AddTiming("sr", sr+offset)
@@ -6,6 +6,7 @@ import (
 	"github.com/flachnetz/dd-zipkin-proxy/proxy"
 	"github.com/sirupsen/logrus"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -15,12 +16,32 @@ var logTraces = os.Getenv("DD_LOG_TRACES") == "true"
 const flushInterval = 2 * time.Second
 const flushSpanCount = 1000
 
+// tag to send the upper 64 bits of a 128 bit trace id to datadog
+const tagTraceIdHigh = "_dd.p.tid"
+
+// tag to send the span events to datadog, encoded as json
+const tagEvents = "events"
+
+// metric to send the sampling priority of the trace to datadog
+const metricSamplingPriority = "_sampling_priority_v1"
+
+// tags datadog uses to describe the service and host a span talks to
+const tagPeerService = "peer.service"
+const tagOutHost = "out.host"
+const tagOutPort = "out.port"
+
+type spanEvent struct {
+	TimeUnixNano int64             `json:"time_unix_nano"`
+	Name         string            `json:"name"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
 // Create a new default transport.
 func DefaultTransport(hostname, port string) tracer.Transport {
 	return tracer.NewTransport(hostname, port)
 }
 
-func submitTraces(transport tracer.Transport, spansByTrace <-chan map[uint64][]*tracer.Span) {
+func submitTraces(transport tracer.Transport, spansByTrace <-chan map[proxy.TraceId][]*tracer.Span) {
 	for buffer := range spansByTrace {
 		count := 0
 
@@ -53,9 +74,9 @@ func Sink(transport tracer.Transport, tracesCh <-chan proxy.Trace) {
 	defer ticker.Stop()
 
 	spanCount := 0
-	byTrace := make(map[uint64][]*tracer.Span)
+	byTrace := make(map[proxy.TraceId][]*tracer.Span)
 
-	groupedSpans := make(chan map[uint64][]*tracer.Span, 8)
+	groupedSpans := make(chan map[proxy.TraceId][]*tracer.Span, 8)
 	defer close(groupedSpans)
 
 	// send the traces in background
@@ -118,12 +139,15 @@ func Sink(transport tracer.Transport, tracesCh <-chan proxy.Trace) {
 					TraceID:  span.Trace.Uint64(),
 					ParentID: span.Parent.Uint64(),
 
-					Meta:    span.Tags,
-					Sampled: true,
+					Meta:    metaOf(span),
+					Metrics: metricsOf(span),
+					Sampled: span.Sampling.IsSampled(),
 					Error:   isError,
 				}
 
-				byTrace[converted.TraceID] = append(byTrace[converted.TraceID], converted)
+				// group by the full trace id, traces might only differ in the upper 64 bits
+				traceId := span.TraceId()
+				byTrace[traceId] = append(byTrace[traceId], converted)
 			}
 
 			spanCount += len(trace)
@@ -144,8 +168,92 @@ func Sink(transport tracer.Transport, tracesCh <-chan proxy.Trace) {
 
 			// reset collection
 			spanCount = 0
-			byTrace = make(map[uint64][]*tracer.Span)
+			byTrace = make(map[proxy.TraceId][]*tracer.Span)
 			lastFlushTime = time.Now()
 		}
 	}
 }
+
+// Returns the tags of the span to send as meta to datadog. The tags are copied
+// if we need to add something, as they might be shared with other consumers.
+func metaOf(span proxy.Span) map[string]string {
+	peer := peerOf(span)
+	if span.TraceHigh == 0 && len(span.Events) == 0 && peer == nil {
+		return span.Tags
+	}
+
+	meta := make(map[string]string, len(span.Tags)+5)
+	for key, value := range span.Tags {
+		meta[key] = value
+	}
+
+	if span.TraceHigh != 0 {
+		meta[tagTraceIdHigh] = span.TraceHigh.String()
+	}
+
+	if len(span.Events) > 0 {
+		meta[tagEvents] = encodeEvents(span.Events)
+	}
+
+	if peer != nil {
+		// tags sent by the client take precedence
+		setIfMissing(meta, tagPeerService, peer.ServiceName)
+		setIfMissing(meta, tagOutHost, peer.Host())
+
+		if peer.Port > 0 {
+			setIfMissing(meta, tagOutPort, strconv.Itoa(peer.Port))
+		}
+	}
+
+	return meta
+}
+
+// Returns the metrics of the span to send to datadog, including the
+// sampling priority. Like the tags, the metrics are copied if needed.
+func metricsOf(span proxy.Span) map[string]float64 {
+	priority, decided := span.Sampling.EffectivePriority()
+	if !decided {
+		return span.Metrics
+	}
+
+	metrics := make(map[string]float64, len(span.Metrics)+1)
+	for key, value := range span.Metrics {
+		metrics[key] = value
+	}
+
+	metrics[metricSamplingPriority] = float64(priority)
+
+	return metrics
+}
+
+// Returns the remote endpoint of a span that calls another service. Datadog describes
+// only outgoing calls with peer tags, so the remote endpoint of a server span is ignored.
+func peerOf(span proxy.Span) *proxy.Endpoint {
+	switch span.Kind {
+	case proxy.KindServer, proxy.KindConsumer:
+		return nil
+
+	default:
+		return span.RemoteEndpoint
+	}
+}
+
+func setIfMissing(meta map[string]string, key, value string) {
+	if _, ok := meta[key]; !ok && value != "" {
+		meta[key] = value
+	}
+}
+
+func encodeEvents(events []proxy.Event) string {
+	converted := make([]spanEvent, len(events))
+	for idx, event := range events {
+		converted[idx] = spanEvent{
+			TimeUnixNano: event.Timestamp.ToNanos(),
+			Name:         event.Name,
+			Attributes:   event.Tags,
+		}
+	}
+
+	encoded, _ := json.Marshal(converted)
+	return string(encoded)
+}
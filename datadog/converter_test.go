@@ -0,0 +1,170 @@
+package datadog
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMetaOf_PeerTags(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cases := []struct {
+		name     string
+		span     proxy.Span
+		expected map[string]string
+	}{
+		{
+			name: "ipv4 and port",
+			span: proxy.Span{
+				Kind:           proxy.KindClient,
+				RemoteEndpoint: &proxy.Endpoint{IPv4: "10.0.0.1", Port: 8080},
+			},
+			expected: map[string]string{tagOutHost: "10.0.0.1", tagOutPort: "8080"},
+		},
+		{
+			name: "ipv6 without port",
+			span: proxy.Span{
+				Kind:           proxy.KindClient,
+				RemoteEndpoint: &proxy.Endpoint{IPv6: "2001:db8::1"},
+			},
+			expected: map[string]string{tagOutHost: "2001:db8::1"},
+		},
+		{
+			name: "ipv4 is preferred over ipv6",
+			span: proxy.Span{
+				Kind:           proxy.KindProducer,
+				RemoteEndpoint: &proxy.Endpoint{IPv4: "10.0.0.1", IPv6: "2001:db8::1"},
+			},
+			expected: map[string]string{tagOutHost: "10.0.0.1"},
+		},
+		{
+			name: "service name",
+			span: proxy.Span{
+				Kind:           proxy.KindClient,
+				RemoteEndpoint: &proxy.Endpoint{ServiceName: "database", IPv4: "10.0.0.1", Port: 5432},
+			},
+			expected: map[string]string{tagPeerService: "database", tagOutHost: "10.0.0.1", tagOutPort: "5432"},
+		},
+		{
+			name: "existing tags are not overwritten",
+			span: proxy.Span{
+				Kind:           proxy.KindClient,
+				Tags:           map[string]string{tagPeerService: "orders-db", tagOutHost: "db.local", "sql.query": "select 1"},
+				RemoteEndpoint: &proxy.Endpoint{ServiceName: "database", IPv4: "10.0.0.1", Port: 5432},
+			},
+			expected: map[string]string{tagPeerService: "orders-db", tagOutHost: "db.local", tagOutPort: "5432", "sql.query": "select 1"},
+		},
+		{
+			name: "server spans have no peer",
+			span: proxy.Span{
+				Kind:           proxy.KindServer,
+				Tags:           map[string]string{"http.path": "/"},
+				RemoteEndpoint: &proxy.Endpoint{ServiceName: "frontend", IPv4: "10.0.0.1", Port: 41234},
+			},
+			expected: map[string]string{"http.path": "/"},
+		},
+	}
+
+	for _, c := range cases {
+		g.Expect(metaOf(c.span)).To(Equal(c.expected), c.name)
+	}
+}
+
+func TestMetaOf_CopiesTags(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tags := map[string]string{"http.path": "/"}
+
+	span := proxy.Span{
+		Kind:           proxy.KindClient,
+		Tags:           tags,
+		RemoteEndpoint: &proxy.Endpoint{ServiceName: "database"},
+	}
+
+	g.Expect(metaOf(span)).To(HaveKeyWithValue(tagPeerService, "database"))
+
+	// the tags might be shared with other consumers of the span
+	g.Expect(tags).To(Equal(map[string]string{"http.path": "/"}))
+}
+
+func TestMetricsOf_SamplingPriority(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	priorityOf := func(sampling proxy.Sampling) interface{} {
+		span := proxy.Span{
+			Metrics:  map[string]float64{"http.status_code": 200},
+			Sampling: sampling,
+		}
+
+		metrics := metricsOf(span)
+		g.Expect(metrics).To(HaveKeyWithValue("http.status_code", 200.0))
+
+		if value, ok := metrics[metricSamplingPriority]; ok {
+			return value
+		}
+
+		return nil
+	}
+
+	var rejected proxy.Sampling
+	rejected.SetSampled(false)
+
+	var sampled proxy.Sampling
+	sampled.SetSampled(true)
+
+	var userKeep proxy.Sampling
+	userKeep.SetPriority(proxy.PriorityUserKeep)
+
+	var userReject proxy.Sampling
+	userReject.SetPriority(proxy.PriorityUserReject)
+
+	// no decision, no priority
+	g.Expect(priorityOf(proxy.Sampling{})).To(BeNil())
+
+	// debug spans are always kept, even if the sampler dropped them
+	g.Expect(priorityOf(proxy.Sampling{Debug: true})).To(Equal(float64(proxy.PriorityUserKeep)))
+	g.Expect(priorityOf(proxy.Sampling{Debug: true, Decided: true, Priority: proxy.PriorityAutoReject})).To(Equal(float64(proxy.PriorityUserKeep)))
+
+	// the b3 sampled flag
+	g.Expect(priorityOf(rejected)).To(Equal(float64(proxy.PriorityAutoReject)))
+	g.Expect(priorityOf(sampled)).To(Equal(float64(proxy.PriorityAutoKeep)))
+
+	// an explicit priority wins over the sampled flag, on either side of a merged span
+	g.Expect(priorityOf(rejected.Merge(userKeep))).To(Equal(float64(proxy.PriorityUserKeep)))
+	g.Expect(priorityOf(userKeep.Merge(rejected))).To(Equal(float64(proxy.PriorityUserKeep)))
+	g.Expect(priorityOf(sampled.Merge(userReject))).To(Equal(float64(proxy.PriorityUserReject)))
+}
+
+func TestMetricsOf_CopiesMetrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	metrics := map[string]float64{"http.status_code": 200}
+
+	span := proxy.Span{Metrics: metrics}
+	span.Sampling.SetSampled(true)
+
+	g.Expect(metricsOf(span)).To(HaveKeyWithValue(metricSamplingPriority, 1.0))
+
+	// the metrics might be shared with other consumers of the span
+	g.Expect(metrics).To(Equal(map[string]float64{"http.status_code": 200}))
+}
+
+func TestMetaOf_TraceIdHigh(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	span := proxy.Span{
+		Trace:     0x48485a3953bb6124,
+		TraceHigh: 0x463ac35c9f6413ad,
+		Tags:      map[string]string{"http.path": "/"},
+	}
+
+	g.Expect(metaOf(span)).To(Equal(map[string]string{
+		"http.path":    "/",
+		tagTraceIdHigh: "463ac35c9f6413ad",
+	}))
+
+	// no tag for 64 bit trace ids
+	span.TraceHigh = 0
+	g.Expect(metaOf(span)).ToNot(HaveKey(tagTraceIdHigh))
+}
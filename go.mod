@@ -8,7 +8,9 @@ require (
 	github.com/flachnetz/go-admin v1.5.3
 	github.com/flachnetz/startup/v2 v2.1.11
 	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/klauspost/compress v1.9.8
 	github.com/modern-go/reflect2 v1.0.1
 	github.com/onsi/gomega v1.5.0
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
@@ -16,6 +18,7 @@ require (
 	github.com/pkg/profile v1.3.0
 	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0
 	github.com/sirupsen/logrus v1.4.2
-	github.com/ugorji/go v1.1.8 // indirect
-	google.golang.org/protobuf v1.25.0 // indirect
+	github.com/ugorji/go/codec v1.1.8
+	google.golang.org/grpc v1.27.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,149 @@
+package zipkinproxy
+
+import (
+	"bytes"
+	"context"
+	"github.com/flachnetz/dd-zipkin-proxy/codec"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+)
+
+// A protobuf message that is not decoded by grpc but
+// passed through as raw bytes to our own decoders.
+type rawMessage []byte
+
+// Codec that does not do any decoding, but just passes around rawMessage values.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(*rawMessage)
+	if !ok {
+		return nil, errors.Errorf("can not marshal value of type %T", v)
+	}
+
+	return *message, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(*rawMessage)
+	if !ok {
+		return errors.Errorf("can not unmarshal into value of type %T", v)
+	}
+
+	*message = data
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "proto"
+}
+
+// The TraceService of the opentelemetry collector protocol.
+type otlpTraceServer interface {
+	Export(ctx context.Context, request rawMessage) (rawMessage, error)
+}
+
+type otlpTraceService struct {
+	queue *spanQueue
+	auth  *authenticator
+}
+
+func (service *otlpTraceService) Export(ctx context.Context, request rawMessage) (rawMessage, error) {
+	ctx, err := service.auth.AuthenticateGrpc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ingestResult
+	metrics.GetOrRegisterTimer("spans.receive[type:otlp-grpc]", nil).Time(func() {
+		result, err = parseSpans(ctx, service.queue, bytes.NewReader(request), "proto", codec.ParseOtlpProto)
+	})
+
+	if _, ok := errors.Cause(err).(*limitExceededError); ok {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	switch errors.Cause(err) {
+	case nil:
+		return encodeOtlpExportResponse(result), nil
+
+	case errQueueBusy:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+
+	case errQueueFull:
+		return nil, status.Error(codes.Unavailable, err.Error())
+
+	default:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+func otlpExportHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var request rawMessage
+	if err := dec(&request); err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		response, err := srv.(otlpTraceServer).Export(ctx, *req.(*rawMessage))
+		return &response, err
+	}
+
+	if interceptor == nil {
+		return handler(ctx, &request)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/opentelemetry.proto.collector.trace.v1.TraceService/Export",
+	}
+
+	return interceptor(ctx, &request, info, handler)
+}
+
+var otlpTraceServiceDesc = grpc.ServiceDesc{
+	ServiceName: "opentelemetry.proto.collector.trace.v1.TraceService",
+	HandlerType: (*otlpTraceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Export", Handler: otlpExportHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "opentelemetry/proto/collector/trace/v1/trace_service.proto",
+}
+
+// Starts a grpc server in the background that accepts OTLP traces on the given
+// address and puts the spans into the provided queue. Calls need to send one of the
+// api keys of the authenticator, if any. Messages are limited to the maximum body size
+// of http requests. The returned function stops the server.
+func serveOtlpGrpc(address string, queue *spanQueue, auth *authenticator, limits requestLimits) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen for grpc connections")
+	}
+
+	maxMessageSize := math.MaxInt32
+	if limits.MaxBodySize > 0 && limits.MaxBodySize < math.MaxInt32 {
+		maxMessageSize = int(limits.MaxBodySize)
+	}
+
+	// grpc.ForceServerCodec replaces CustomCodec in later versions of grpc
+	server := grpc.NewServer(
+		grpc.CustomCodec(rawCodec{}),
+		grpc.MaxRecvMsgSize(maxMessageSize),
+	)
+
+	server.RegisterService(&otlpTraceServiceDesc, &otlpTraceService{queue: queue, auth: auth})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Warnf("Grpc server stopped: %s", err)
+		}
+	}()
+
+	return server.GracefulStop, nil
+}
@@ -0,0 +1,102 @@
+package zipkinproxy
+
+import (
+	"context"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOtlpGrpcExport(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 16)
+
+	server := grpc.NewServer(grpc.CustomCodec(rawCodec{}))
+	server.RegisterService(&otlpTraceServiceDesc, &otlpTraceService{queue: newSpanQueue(spans, time.Second, 0), auth: newAuthenticator(nil)})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+
+	export := func(request rawMessage) error {
+		var response rawMessage
+		return conn.Invoke(context.Background(),
+			"/opentelemetry.proto.collector.trace.v1.TraceService/Export",
+			&request, &response, grpc.CallCustomCodec(rawCodec{}))
+	}
+
+	var span []byte
+	span = protowire.AppendTag(span, 1, protowire.BytesType)
+	span = protowire.AppendBytes(span, []byte{0, 0, 0, 0, 0, 0, 0xbe, 0xaf})
+	span = protowire.AppendTag(span, 2, protowire.BytesType)
+	span = protowire.AppendBytes(span, []byte{0, 0, 0, 0, 0, 0, 0xde, 0xad})
+
+	var scopeSpans []byte
+	scopeSpans = protowire.AppendTag(scopeSpans, 2, protowire.BytesType)
+	scopeSpans = protowire.AppendBytes(scopeSpans, span)
+
+	var resourceSpans []byte
+	resourceSpans = protowire.AppendTag(resourceSpans, 2, protowire.BytesType)
+	resourceSpans = protowire.AppendBytes(resourceSpans, scopeSpans)
+
+	var request []byte
+	request = protowire.AppendTag(request, 1, protowire.BytesType)
+	request = protowire.AppendBytes(request, resourceSpans)
+
+	g.Expect(export(request)).To(Succeed())
+	g.Expect(spans).To(HaveLen(1))
+
+	received := <-spans
+	g.Expect(received.Trace).To(Equal(proxy.Id(0xbeaf)))
+	g.Expect(received.Id).To(Equal(proxy.Id(0xdead)))
+
+	// broken input must be rejected
+	err = export(request[:len(request)-1])
+	g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+}
+
+func TestOtlpGrpcExport_Limits(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	service := &otlpTraceService{
+		queue: newSpanQueue(make(chan proxy.Span, 16), time.Second, 1),
+		auth:  newAuthenticator(nil),
+	}
+
+	var scopeSpans []byte
+	for _, id := range []byte{0xaa, 0xbb} {
+		var span []byte
+		span = protowire.AppendTag(span, 1, protowire.BytesType)
+		span = protowire.AppendBytes(span, []byte{0, 0, 0, 0, 0, 0, 0xbe, 0xaf})
+		span = protowire.AppendTag(span, 2, protowire.BytesType)
+		span = protowire.AppendBytes(span, []byte{0, 0, 0, 0, 0, 0, 0xde, id})
+
+		scopeSpans = protowire.AppendTag(scopeSpans, 2, protowire.BytesType)
+		scopeSpans = protowire.AppendBytes(scopeSpans, span)
+	}
+
+	var resourceSpans []byte
+	resourceSpans = protowire.AppendTag(resourceSpans, 2, protowire.BytesType)
+	resourceSpans = protowire.AppendBytes(resourceSpans, scopeSpans)
+
+	var request []byte
+	request = protowire.AppendTag(request, 1, protowire.BytesType)
+	request = protowire.AppendBytes(request, resourceSpans)
+
+	// exceeding a limit is not the fault of the message itself
+	_, err := service.Export(context.Background(), request)
+	g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+}
@@ -1,27 +1,45 @@
 package zipkinproxy
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"github.com/flachnetz/dd-zipkin-proxy/codec"
 	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/golang/snappy"
 	"github.com/julienschmidt/httprouter"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"github.com/rcrowley/go-metrics"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"strings"
 )
 
-func handleSpans(r *httprouter.Router, spans chan<- proxy.Span) {
-	r.POST("/api/v1/spans", func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+func handleSpans(r *httprouter.Router, queue *spanQueue, auth *authenticator) {
+	r.POST("/api/v1/spans", auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var result ingestResult
 		var err error
-		if strings.Contains(req.Header.Get("Content-Type"), "application/json") {
+		contentType := req.Header.Get("Content-Type")
+
+		switch {
+		case strings.Contains(contentType, "application/json"):
 			metrics.GetOrRegisterTimer("spans.receive[type:json-v1]", nil).Time(func() {
-				err = parseSpansWithJSON(spans, req.Body, codec.ParseJsonV1)
+				result, err = parseSpans(req.Context(), queue, req.Body, "json", codec.ParseJsonV1)
 			})
-		} else {
-			err = errors.New("only json spans are supported")
+
+		case strings.Contains(contentType, "application/x-thrift"):
+			metrics.GetOrRegisterTimer("spans.receive[type:thrift-v1]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "thrift", codec.ParseThriftV1)
+			})
+
+		default:
+			err = errors.New("only json and thrift spans are supported")
 		}
 
 		if rand.Float64() < 0.01 {
@@ -30,70 +48,326 @@ func handleSpans(r *httprouter.Router, spans chan<- proxy.Span) {
 		}
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusBadRequest)
+			writeSpansError(writer, req, queue, err)
 		} else {
-			writer.WriteHeader(http.StatusAccepted)
+			writeIngestResult(writer, http.StatusAccepted, result)
 		}
-	})
+	}))
 
-	r.POST("/api/v2/spans", func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	r.POST("/api/v2/spans", auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var result ingestResult
 		var err error
-		metrics.GetOrRegisterTimer("spans.receive[type:json-v2]", nil).Time(func() {
-			err = parseSpansWithJSON(spans, req.Body, codec.ParseJsonV2)
-		})
+		if strings.Contains(req.Header.Get("Content-Type"), "application/x-protobuf") {
+			metrics.GetOrRegisterTimer("spans.receive[type:proto-v2]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "proto", codec.ParseProtoV2)
+			})
+		} else {
+			metrics.GetOrRegisterTimer("spans.receive[type:json-v2]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "json", codec.ParseJsonV2)
+			})
+		}
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusBadRequest)
+			writeSpansError(writer, req, queue, err)
 		} else {
-			writer.WriteHeader(http.StatusAccepted)
+			writeIngestResult(writer, http.StatusAccepted, result)
 		}
-	})
+	}))
 
-	r.POST("/api/jaeger/spans", func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	r.POST("/api/jaeger/spans", auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var result ingestResult
 		var err error
 		metrics.GetOrRegisterTimer("spans.receive[type:jaeger]", nil).Time(func() {
-			err = parseSpansWithJSON(spans, req.Body, codec.ParseJaeger)
+			result, err = parseSpans(req.Context(), queue, req.Body, "json", codec.ParseJaeger)
 		})
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusBadRequest)
+			writeSpansError(writer, req, queue, err)
+		} else {
+			writeIngestResult(writer, http.StatusAccepted, result)
+		}
+	}))
+
+	r.POST("/api/traces", auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var result ingestResult
+		var err error
+		if strings.Contains(req.Header.Get("Content-Type"), "application/x-thrift") {
+			metrics.GetOrRegisterTimer("spans.receive[type:jaeger-thrift]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "thrift", codec.ParseJaegerThriftBinary)
+			})
+		} else {
+			err = errors.New("only thrift spans are supported")
+		}
+
+		if err != nil {
+			writeSpansError(writer, req, queue, err)
 		} else {
-			writer.WriteHeader(http.StatusAccepted)
+			writeIngestResult(writer, http.StatusAccepted, result)
+		}
+	}))
+
+	handleDatadogTraces := auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var err error
+		if strings.Contains(req.Header.Get("Content-Type"), "application/json") {
+			metrics.GetOrRegisterTimer("spans.receive[type:datadog-json]", nil).Time(func() {
+				_, err = parseSpans(req.Context(), queue, req.Body, "json", codec.ParseDatadogJson)
+			})
+		} else {
+			metrics.GetOrRegisterTimer("spans.receive[type:datadog-msgpack]", nil).Time(func() {
+				_, err = parseSpans(req.Context(), queue, req.Body, "msgpack", codec.ParseDatadogMsgpack)
+			})
+		}
+
+		if err != nil {
+			writeSpansError(writer, req, queue, err)
+			return
+		}
+
+		// tracers using the v0.4 api expect sampling rates in the response
+		if strings.HasPrefix(req.URL.Path, "/v0.4/") {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write([]byte(`{"rate_by_service":{}}`))
+		} else {
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write([]byte("OK\n"))
 		}
 	})
+
+	r.PUT("/v0.3/traces", handleDatadogTraces)
+	r.POST("/v0.3/traces", handleDatadogTraces)
+	r.PUT("/v0.4/traces", handleDatadogTraces)
+	r.POST("/v0.4/traces", handleDatadogTraces)
+
+	r.POST("/v1/traces", auth.Handle(func(writer http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		var result ingestResult
+		var err error
+		contentType := req.Header.Get("Content-Type")
+
+		switch {
+		case strings.Contains(contentType, "application/x-protobuf"):
+			metrics.GetOrRegisterTimer("spans.receive[type:otlp-proto]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "proto", codec.ParseOtlpProto)
+			})
+
+		case strings.Contains(contentType, "application/json"):
+			metrics.GetOrRegisterTimer("spans.receive[type:otlp-json]", nil).Time(func() {
+				result, err = parseSpans(req.Context(), queue, req.Body, "json", codec.ParseOtlpJson)
+			})
+
+		default:
+			err = errors.New("only json and protobuf spans are supported")
+		}
+
+		if err != nil {
+			writeSpansError(writer, req, queue, err)
+			return
+		}
+
+		// respond with an ExportTraceServiceResponse in the encoding of the request
+		if strings.Contains(contentType, "application/json") {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(encodeOtlpExportResponseJson(result))
+		} else {
+			writer.Header().Set("Content-Type", "application/x-protobuf")
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(encodeOtlpExportResponse(result))
+		}
+	}))
+}
+
+// Decoders for the content encodings we accept for request bodies. The decoders
+// get the maximum size of the decoded body, zero if not limited.
+var requestBodyDecoders = map[string]func(r io.Reader, maxSize int64) (io.ReadCloser, error){
+	"gzip":    newGzipReader,
+	"deflate": newDeflateReader,
+	"zstd":    newZstdReader,
+	"snappy":  newSnappyReader,
 }
 
-func handleGzipRequestBody(handle http.Handler) http.HandlerFunc {
+func handleEncodedRequestBody(handle http.Handler, limits requestLimits) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		rejectTooLarge := func(err *limitExceededError) {
+			markLimitExceeded(req, err)
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+
+		errBodyTooLarge := newBodyTooLargeError("body", "request body", limits.MaxBodySize)
+		errDecodedBodyTooLarge := newBodyTooLargeError("decoded-body", "decompressed request body", limits.MaxDecodedBodySize)
+
+		// fail early if the client tells us about the size of the body
+		if limits.MaxBodySize > 0 && req.ContentLength > limits.MaxBodySize {
+			rejectTooLarge(errBodyTooLarge)
+			return
+		}
+
+		// set once the compressed or decompressed body exceeds its limit
+		var errLimit *limitExceededError
+
+		req.Body = newLimitedBody(req.Body, limits.MaxBodySize, errBodyTooLarge, &errLimit)
+
 		// decode body on the fly if it comes compressed
-		if req.Header.Get("Content-Encoding") == "gzip" {
-			body, err := gzip.NewReader(req.Body)
+		encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+		if encoding != "" && encoding != "identity" {
+			decoder, ok := requestBodyDecoders[encoding]
+			if !ok {
+				http.Error(writer, "unsupported content encoding: "+encoding, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			body, err := decoder(req.Body, limits.MaxDecodedBodySize)
 			if err != nil {
-				http.Error(writer, err.Error(), http.StatusBadRequest)
+				if errLimit == nil {
+					errLimit, _ = err.(*limitExceededError)
+				}
+
+				if errLimit != nil {
+					rejectTooLarge(errLimit)
+				} else {
+					http.Error(writer, err.Error(), http.StatusBadRequest)
+				}
+
 				return
 			}
 
+			defer body.Close()
+
 			req.Body = body
 			req.Header.Del("Content-Encoding")
 			req.Header.Del("Content-Length")
 		}
 
+		req.Body = newLimitedBody(req.Body, limits.MaxDecodedBodySize, errDecodedBodyTooLarge, &errLimit)
+
 		handle.ServeHTTP(writer, req)
 	}
 }
 
-func parseSpansWithJSON(spansChannel chan<- proxy.Span, body io.Reader, parser func(io.Reader) ([]proxy.Span, error)) error {
+func newGzipReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func newDeflateReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+
+	// the content encoding 'deflate' is specified to be zlib wrapped,
+	// but some clients send a raw deflate stream.
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+func newZstdReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.IOReadCloser(), nil
+}
+
+// magic bytes at the beginning of the snappy framing format
+var snappyStreamHeader = []byte("\xff\x06\x00\x00sNaPpY")
+
+func newSnappyReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+
+	header, _ := buffered.Peek(len(snappyStreamHeader))
+	if bytes.Equal(header, snappyStreamHeader) {
+		return ioutil.NopCloser(snappy.NewReader(buffered)), nil
+	}
+
+	// not framed, we expect a single snappy block
+	encoded, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the size before allocating memory for the decoded block
+	decodedSize, err := snappy.DecodedLen(encoded)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode snappy block")
+	}
+
+	if maxSize > 0 && int64(decodedSize) > maxSize {
+		return nil, newBodyTooLargeError("decoded-body", "decompressed request body", maxSize)
+	}
+
+	decoded, err := snappy.Decode(nil, encoded)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode snappy block")
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(decoded)), nil
+}
+
+func parseSpans(ctx context.Context, queue *spanQueue, body io.Reader, format string, parser func(io.Reader) ([]proxy.Span, error)) (ingestResult, error) {
 	parsedSpans, err := parser(body)
 	if err != nil {
-		return errors.WithMessage(err, "parsing spans from json")
+		// the parser might hide that the body was too large
+		if errLimit := bodyLimitExceeded(body); errLimit != nil {
+			return ingestResult{}, errLimit
+		}
+
+		return ingestResult{}, errors.WithMessage(err, "parsing spans from "+format)
 	}
 
-	for _, span := range parsedSpans {
-		spansChannel <- span
+	// invalid spans count towards the limit too
+	if err := queue.CheckSpanCount(len(parsedSpans)); err != nil {
+		return ingestResult{}, err
 	}
 
-	spanCount := int64(len(parsedSpans))
-	metrics.GetOrRegisterMeter("spans.parsed[type:json]", nil).Mark(spanCount)
+	key := apiKeyFromContext(ctx)
+
+	validSpans, result := validateSpans(parsedSpans, key)
+
+	if err := queue.Enqueue(validSpans); err != nil {
+		return ingestResult{}, err
+	}
+
+	markIngested(format, key, result)
+
+	return result, nil
+}
+
+// Enqueues the spans of a message consumed from kafka. Other than a request, a message
+// can not be retried by the client, so enqueueing is retried until the pipeline catches
+// up. The consumer does not fetch new messages in the meantime.
+func enqueueConsumedSpans(queue *spanQueue, spans []proxy.Span, format string) error {
+	if err := queue.CheckSpanCount(len(spans)); err != nil {
+		return err
+	}
+
+	validSpans, result := validateSpans(spans, nil)
+
+	for {
+		err := queue.Enqueue(validSpans)
+		if err == nil {
+			break
+		}
+
+		if err != errQueueBusy && err != errQueueFull {
+			return err
+		}
+	}
+
+	markIngested(format, nil, result)
 
 	return nil
 }
+
+func markIngested(format string, key *apiKey, result ingestResult) {
+	metrics.GetOrRegisterMeter("spans.parsed[type:"+format+"]", nil).Mark(int64(result.Accepted))
+
+	if result.Rejected > 0 {
+		metrics.GetOrRegisterMeter("spans.invalid[type:"+format+"]", nil).Mark(int64(result.Rejected))
+	}
+
+	if key != nil {
+		metrics.GetOrRegisterMeter("spans.accepted[key:"+key.Name+"]", nil).Mark(int64(result.Accepted))
+	}
+}
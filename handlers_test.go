@@ -0,0 +1,118 @@
+package zipkinproxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/gomega"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleEncodedRequestBody(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	payload := []byte(`[{"traceId": "beaf", "id": "dead"}]`)
+
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		_, _ = w.Write(payload)
+		_ = w.Close()
+		return buf.Bytes()
+	}
+
+	cases := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", payload},
+		{"gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+
+		// raw deflate without zlib wrapper
+		{"deflate", compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+
+		{"zstd", compress(func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		})},
+
+		// snappy as single block and using the framing format
+		{"snappy", snappy.Encode(nil, payload)},
+		{"snappy", compress(func(w io.Writer) io.WriteCloser { return snappy.NewBufferedWriter(w) })},
+	}
+
+	handler := handleEncodedRequestBody(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(body).To(Equal(payload))
+		g.Expect(req.Header.Get("Content-Encoding")).To(BeEmpty())
+
+		writer.WriteHeader(http.StatusAccepted)
+	}), requestLimits{})
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/v2/spans", bytes.NewReader(c.body))
+		req.Header.Set("Content-Encoding", c.encoding)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+
+		g.Expect(recorder.Code).To(Equal(http.StatusAccepted), "encoding %q", c.encoding)
+	}
+}
+
+func TestHandleEncodedRequestBody_Unsupported(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	handler := handleEncodedRequestBody(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		t.Fatal("handler must not be called")
+	}), requestLimits{})
+
+	req := httptest.NewRequest("POST", "/api/v2/spans", bytes.NewReader([]byte("data")))
+	req.Header.Set("Content-Encoding", "br")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+
+	g.Expect(recorder.Code).To(Equal(http.StatusUnsupportedMediaType))
+	g.Expect(recorder.Body.String()).To(ContainSubstring("br"))
+}
+
+func TestEnqueueConsumedSpans(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 2)
+	queue := newSpanQueue(spans, 10*time.Millisecond, 0)
+
+	// the channel is full, so the first attempts to enqueue the message fail
+	spans <- proxy.Span{Trace: 1, Id: 1}
+	spans <- proxy.Span{Trace: 1, Id: 2}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		<-spans
+		<-spans
+	}()
+
+	// the invalid span without trace id is dropped, the valid one is retried until it fits
+	consumed := []proxy.Span{{Trace: 2, Id: 3}, {Id: 4}}
+	g.Expect(enqueueConsumedSpans(queue, consumed, "kafka-zipkin")).To(Succeed())
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect((<-spans).Id).To(Equal(proxy.Id(3)))
+
+	// messages exceeding the limit are not retried
+	g.Expect(enqueueConsumedSpans(queue, make([]proxy.Span, 3), "kafka-zipkin")).To(HaveOccurred())
+}
@@ -0,0 +1,133 @@
+package zipkinproxy
+
+import (
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// number of rejected spans we report back to the client in detail
+const maxReportedRejections = 10
+
+// Tells the client how many of its spans were accepted.
+type ingestResult struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+
+	// the first few reasons why spans were rejected
+	Rejections []spanRejection `json:"rejections,omitempty"`
+}
+
+type spanRejection struct {
+	// index of the span in the request
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// Checks if we are able to process the span and if the api key is allowed to send it.
+// Returns the reason why the span is invalid or an empty string.
+func validateSpan(span *proxy.Span, key *apiKey) string {
+	switch {
+	case span.Trace == 0:
+		return "trace id is missing"
+
+	case span.Id == 0:
+		return "span id is missing"
+
+	case !key.Allows(span.Service):
+		return "api key is not allowed to send spans for service '" + span.Service + "'"
+
+	default:
+		return ""
+	}
+}
+
+// Removes invalid spans from the slice in place and
+// returns the remaining spans together with the result.
+func validateSpans(spans []proxy.Span, key *apiKey) ([]proxy.Span, ingestResult) {
+	var result ingestResult
+
+	valid := spans[:0]
+	for idx := range spans {
+		reason := validateSpan(&spans[idx], key)
+		if reason == "" {
+			valid = append(valid, spans[idx])
+			continue
+		}
+
+		result.Rejected++
+
+		if len(result.Rejections) < maxReportedRejections {
+			result.Rejections = append(result.Rejections, spanRejection{Index: idx, Reason: reason})
+		}
+	}
+
+	result.Accepted = len(valid)
+
+	return valid, result
+}
+
+// Writes the result as json. If no span was accepted at all, the
+// request is answered with 400, otherwise with the given status code.
+func writeIngestResult(writer http.ResponseWriter, statusCode int, result ingestResult) {
+	if result.Accepted == 0 && result.Rejected > 0 {
+		statusCode = http.StatusBadRequest
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	_ = json.NewEncoder(writer).Encode(result)
+}
+
+// Summarizes the rejections in one message, e.g. for the partial success of an OTLP response.
+func (result ingestResult) Message() string {
+	var reasons []string
+	for _, rejection := range result.Rejections {
+		reasons = append(reasons, "span "+strconv.Itoa(rejection.Index)+": "+rejection.Reason)
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// Encodes an ExportTraceServiceResponse. Rejected spans are reported as partial success.
+func encodeOtlpExportResponse(result ingestResult) []byte {
+	if result.Rejected == 0 {
+		return nil
+	}
+
+	var partialSuccess []byte
+	partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+	partialSuccess = protowire.AppendVarint(partialSuccess, uint64(result.Rejected))
+	partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+	partialSuccess = protowire.AppendString(partialSuccess, result.Message())
+
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.BytesType)
+	response = protowire.AppendBytes(response, partialSuccess)
+
+	return response
+}
+
+// Encodes an ExportTraceServiceResponse as json. Rejected spans are reported as partial success.
+func encodeOtlpExportResponseJson(result ingestResult) []byte {
+	if result.Rejected == 0 {
+		return []byte("{}")
+	}
+
+	type partialSuccess struct {
+		RejectedSpans string `json:"rejectedSpans"`
+		ErrorMessage  string `json:"errorMessage"`
+	}
+
+	encoded, _ := json.Marshal(map[string]partialSuccess{
+		"partialSuccess": {
+			RejectedSpans: strconv.Itoa(result.Rejected),
+			ErrorMessage:  result.Message(),
+		},
+	})
+
+	return encoded
+}
@@ -0,0 +1,91 @@
+package zipkinproxy
+
+import (
+	"encoding/json"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSpans(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := []proxy.Span{
+		{Trace: 1, Id: 1},
+		{Trace: 0, Id: 2},
+		{Trace: 1, Id: 3},
+		{Trace: 1, Id: 0},
+	}
+
+	valid, result := validateSpans(spans, nil)
+
+	g.Expect(valid).To(Equal([]proxy.Span{{Trace: 1, Id: 1}, {Trace: 1, Id: 3}}))
+	g.Expect(result).To(Equal(ingestResult{
+		Accepted: 2,
+		Rejected: 2,
+		Rejections: []spanRejection{
+			{Index: 1, Reason: "trace id is missing"},
+			{Index: 3, Reason: "span id is missing"},
+		},
+	}))
+}
+
+func TestIngestResponse(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 16)
+
+	router := httprouter.New()
+	handleSpans(router, newSpanQueue(spans, time.Second, 0), newAuthenticator(nil))
+
+	post := func(body string) (int, ingestResult) {
+		req := httptest.NewRequest("POST", "/api/v2/spans", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		var result ingestResult
+		g.Expect(json.Unmarshal(recorder.Body.Bytes(), &result)).To(Succeed())
+
+		return recorder.Code, result
+	}
+
+	code, result := post(`[{"traceId": "beaf", "id": "dead"}, {"id": "dead"}]`)
+	g.Expect(code).To(Equal(http.StatusAccepted))
+	g.Expect(result).To(Equal(ingestResult{
+		Accepted:   1,
+		Rejected:   1,
+		Rejections: []spanRejection{{Index: 1, Reason: "trace id is missing"}},
+	}))
+
+	g.Expect(spans).To(HaveLen(1))
+
+	// nothing was accepted
+	code, result = post(`[{"id": "dead"}]`)
+	g.Expect(code).To(Equal(http.StatusBadRequest))
+	g.Expect(result.Rejected).To(Equal(1))
+
+	g.Expect(spans).To(HaveLen(1))
+}
+
+func TestEncodeOtlpExportResponseJson(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(string(encodeOtlpExportResponseJson(ingestResult{Accepted: 1}))).To(Equal("{}"))
+
+	encoded := encodeOtlpExportResponseJson(ingestResult{
+		Accepted:   1,
+		Rejected:   2,
+		Rejections: []spanRejection{{Index: 1, Reason: "trace id is missing"}, {Index: 2, Reason: "span id is missing"}},
+	})
+
+	g.Expect(string(encoded)).To(MatchJSON(`{"partialSuccess": {
+		"rejectedSpans": "2",
+		"errorMessage": "span 1: trace id is missing, span 2: span id is missing"}}`))
+}
@@ -0,0 +1,119 @@
+package zipkinproxy
+
+import (
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"math"
+	"net/http"
+)
+
+// Limits for a single request. A value of zero disables the limit.
+type requestLimits struct {
+	// size of the request body as sent by the client
+	MaxBodySize int64
+
+	// size of the request body after decompression
+	MaxDecodedBodySize int64
+}
+
+// Returned if a request exceeds one of the configured limits.
+type limitExceededError struct {
+	// name of the limit for metrics, e.g. 'body' or 'spans'
+	limit string
+	text  string
+}
+
+func (err *limitExceededError) Error() string {
+	return err.text
+}
+
+func newBodyTooLargeError(limit string, description string, maxSize int64) *limitExceededError {
+	return &limitExceededError{
+		limit: limit,
+		text:  fmt.Sprintf("%s exceeds the limit of %d bytes", description, maxSize),
+	}
+}
+
+func newTooManySpansError(spanCount, maxSpans int) *limitExceededError {
+	return &limitExceededError{
+		limit: "spans",
+		text:  fmt.Sprintf("request contains %d spans, only %d spans are allowed per request", spanCount, maxSpans),
+	}
+}
+
+// The routes spans are received on. The limits apply to all requests, but other
+// paths are reported as 'other' so clients can not create any number of meters.
+var spanRoutes = map[string]bool{
+	"/api/v1/spans":     true,
+	"/api/v2/spans":     true,
+	"/api/jaeger/spans": true,
+	"/api/traces":       true,
+	"/v0.3/traces":      true,
+	"/v0.4/traces":      true,
+	"/v1/traces":        true,
+}
+
+func routeOf(req *http.Request) string {
+	if spanRoutes[req.URL.Path] {
+		return req.URL.Path
+	}
+
+	return "other"
+}
+
+func markLimitExceeded(req *http.Request, err *limitExceededError) {
+	metrics.GetOrRegisterMeter("requests.limited[route:"+routeOf(req)+",limit:"+err.limit+"]", nil).Mark(1)
+}
+
+// A request body that fails once more than the allowed number of bytes are read.
+// The compressed and the decompressed body of a request share the error, so
+// that we still know about it if the decompressor swallowed the original error.
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+
+	errLimit *limitExceededError
+	err      **limitExceededError
+}
+
+func newLimitedBody(body io.ReadCloser, maxSize int64, errLimit *limitExceededError, err **limitExceededError) *limitedBody {
+	if maxSize <= 0 {
+		maxSize = math.MaxInt64 - 1
+	}
+
+	return &limitedBody{body: body, remaining: maxSize, errLimit: errLimit, err: err}
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if *body.err != nil {
+		return 0, *body.err
+	}
+
+	// read one byte more than allowed to see if the body is too large
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+
+	n, err := body.body.Read(p)
+	if int64(n) > body.remaining {
+		*body.err = body.errLimit
+		return int(body.remaining), *body.err
+	}
+
+	body.remaining -= int64(n)
+	return n, err
+}
+
+func (body *limitedBody) Close() error {
+	return body.body.Close()
+}
+
+// Returns the error of an exceeded limit, if the body was limited by limitedBody.
+func bodyLimitExceeded(body io.Reader) error {
+	if limited, ok := body.(*limitedBody); ok && *limited.err != nil {
+		return *limited.err
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package zipkinproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/onsi/gomega"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestLimits(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 16)
+
+	router := httprouter.New()
+	handleSpans(router, newSpanQueue(spans, time.Second, 2), newAuthenticator(nil))
+
+	handler := handleEncodedRequestBody(router, requestLimits{
+		MaxBodySize:        512,
+		MaxDecodedBodySize: 1024,
+	})
+
+	post := func(body []byte, encoding string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v2/spans", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+
+		if chunked {
+			// the client does not tell us about the size of the body
+			req.ContentLength = -1
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	span := `{"traceId": "beaf", "id": "dead", "name": "get", "timestamp": 1560276900000000, "duration": 1000}`
+
+	// within all limits
+	g.Expect(post([]byte("["+span+"]"), "", false).Code).To(Equal(http.StatusAccepted))
+	g.Expect(spans).To(HaveLen(1))
+
+	// too many spans
+	response := post([]byte("["+span+","+span+","+span+"]"), "", false)
+	g.Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	g.Expect(response.Body.String()).To(ContainSubstring("3 spans"))
+	g.Expect(spans).To(HaveLen(1))
+
+	// invalid spans count towards the limit
+	invalid := `{"traceId": "beaf", "name": "get"}`
+	response = post([]byte("["+span+","+invalid+","+invalid+"]"), "", false)
+	g.Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	g.Expect(spans).To(HaveLen(1))
+
+	// body too large, detected using the content length and while reading
+	large := []byte("[" + strings.Repeat(" ", 600) + span + "]")
+	g.Expect(post(large, "", false).Code).To(Equal(http.StatusRequestEntityTooLarge))
+	g.Expect(post(large, "", true).Code).To(Equal(http.StatusRequestEntityTooLarge))
+
+	// small compressed body that is too large after decompression
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, _ = w.Write([]byte("[" + strings.Repeat(" ", 4096) + span + "]"))
+	_ = w.Close()
+
+	g.Expect(compressed.Len()).To(BeNumerically("<", 512))
+
+	response = post(compressed.Bytes(), "gzip", true)
+	g.Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	g.Expect(response.Body.String()).To(ContainSubstring("decompressed request body"))
+}
+
+func TestMarkLimitExceeded(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	errLimit := newBodyTooLargeError("body", "request body", 512)
+
+	markLimitExceeded(httptest.NewRequest("POST", "/api/v2/spans", nil), errLimit)
+	g.Expect(metrics.Get("requests.limited[route:/api/v2/spans,limit:body]")).ToNot(BeNil())
+
+	// unknown paths do not create new meters
+	markLimitExceeded(httptest.NewRequest("POST", "/random/4711", nil), errLimit)
+	g.Expect(metrics.Get("requests.limited[route:/random/4711,limit:body]")).To(BeNil())
+	g.Expect(metrics.Get("requests.limited[route:other,limit:body]")).ToNot(BeNil())
+}
@@ -1,6 +1,7 @@
 package zipkinproxy
 
 import (
+	"crypto/tls"
 	"github.com/Shopify/sarama"
 	"github.com/flachnetz/go-admin"
 	"github.com/flachnetz/startup/v2"
@@ -48,10 +49,39 @@ func MainWithRouting(routing Routing, spanConverter SpanConverter) {
 
 			Topic           string `long:"kafka-topic" default:"zipkin-spans" description:"Kafka topic to put spans to. Will be created if it does not exist"`
 			ConsumerGroupId string `long:"kafka-consumer-group" default:"zipkin-proxy" description:"Name of the consumer group to use to load balance zipkin spans."`
+
+			ZipkinTopic           string `long:"kafka-zipkin-topic" description:"Kafka topic zipkin reporters publish spans to, e.g. 'zipkin'. Disabled if not set."`
+			ZipkinEncoding        string `long:"kafka-zipkin-encoding" default:"auto" choice:"auto" choice:"json-v1" choice:"json-v2" choice:"thrift" choice:"proto" description:"Encoding of the spans in the zipkin topic. 'auto' detects the encoding for each message."`
+			ZipkinConsumerGroupId string `long:"kafka-zipkin-consumer-group" default:"zipkin-proxy-input" description:"Name of the consumer group to use to consume the zipkin topic."`
 		} `group:"Load balancing configuration"`
 
+		TLS struct {
+			ClientCAFile   string        `long:"http-tls-client-ca" description:"CA bundle to verify client certificates against. If set, clients must present a valid certificate."`
+			ReloadInterval time.Duration `long:"http-tls-reload-interval" default:"30s" description:"Interval to check the certificate files for changes. Set to zero to disable reloading."`
+		} `group:"TLS options"`
+
 		ProfileCPU bool `long:"profile" description:"Enable CPU profiling"`
 
+		OtlpGrpcAddress    string `long:"otlp-grpc-address" description:"Address to accept OTLP traces via grpc on, e.g. ':4317'. Disabled if not set."`
+		JaegerAgentAddress string `long:"jaeger-agent-address" description:"Address to accept jaeger agent spans via udp on, e.g. ':6831'. Disabled if not set."`
+
+		ApiKeys []string `long:"api-key" description:"Api key clients need to send spans, in format 'name:key' or 'name:key:service1,service2' to limit the key to some services. Can be specified multiple times. Authentication is disabled if not set."`
+
+		IngestTimeout time.Duration `long:"ingest-timeout" default:"2s" description:"Time to wait for room in the processing queue before a request is rejected with 429 or 503."`
+
+		Limits struct {
+			MaxBodySize        int64 `long:"max-body-size" default:"16777216" description:"Maximum size of a request body in bytes as sent by the client. 0 disables the limit."`
+			MaxDecodedBodySize int64 `long:"max-decoded-body-size" default:"67108864" description:"Maximum size of a request body in bytes after decompression. 0 disables the limit."`
+			MaxSpans           int   `long:"max-spans-per-request" default:"50000" description:"Maximum number of spans in a single request. 0 disables the limit."`
+		} `group:"Request limits"`
+
+		SkewCorrection struct {
+			Default  string   `long:"skew-correction" default:"midpoint" choice:"midpoint" choice:"min-latency" choice:"none" description:"How to correct the clock skew between client and server of a call. 'midpoint' centers the server within the client, 'min-latency' corrects like zipkin and only moves the server if it is outside of the client."`
+			Services []string `long:"skew-correction-service" description:"Skew correction for calls between two services in format 'client:server=correction'. Either service can be '*'. Can be specified multiple times."`
+
+			Threshold time.Duration `long:"skew-alert-threshold" default:"1s" description:"Mark the spans.skew.exceeded meter for services with a larger estimated clock skew. Set to zero to disable."`
+		} `group:"Clock skew correction"`
+
 		TraceAgent struct {
 			Host string `long:"trace-host" default:"localhost" description:"Hostname of the trace agent."`
 			Port int    `long:"trace-port" default:"8126" description:"Port of the trace agent."`
@@ -77,6 +107,11 @@ func MainWithRouting(routing Routing, spanConverter SpanConverter) {
 		defer profile.Start().Stop()
 	}
 
+	skewCorrection, err := parseSkewCorrection(opts.SkewCorrection.Default, opts.SkewCorrection.Services)
+	FatalOnError(err, "Invalid skew correction")
+
+	skewCorrection.Threshold = opts.SkewCorrection.Threshold
+
 	var channels []chan<- proxy.Trace
 
 	if true {
@@ -105,8 +140,12 @@ func MainWithRouting(routing Routing, spanConverter SpanConverter) {
 	processedSpans := make(chan proxy.Trace, 64)
 	go forwardSpansToChannels(processedSpans, channels, spanConverter)
 
-	// http handler will put spans into this channel
-	httpInputSpans := make(chan proxy.Span, 256)
+	// http handler will put spans into this channel. A request is enqueued as a
+	// whole, so the channel must be able to take the largest allowed request.
+	httpInputSpans := make(chan proxy.Span, maxInt(256, opts.Limits.MaxSpans))
+
+	// all receivers put their spans into the channel using this queue
+	inputQueue := newSpanQueue(httpInputSpans, opts.IngestTimeout, opts.Limits.MaxSpans)
 
 	if len(opts.Kafka.Addresses) > 0 {
 		log.Infof(
@@ -149,13 +188,97 @@ func MainWithRouting(routing Routing, spanConverter SpanConverter) {
 		defer closeConsumerGroup()
 
 		// send spans received from kafka to processing
-		go ErrorCorrectSpans(kafkaInputSpans, processedSpans)
+		go ErrorCorrectSpans(kafkaInputSpans, processedSpans, skewCorrection)
+
+		if opts.Kafka.ZipkinTopic != "" {
+			log.Infof("Consume zipkin spans from topic %s using encoding %s",
+				opts.Kafka.ZipkinTopic, opts.Kafka.ZipkinEncoding)
+
+			zipkinConsumerGroup, err := sarama.NewConsumerGroupFromClient(opts.Kafka.ZipkinConsumerGroupId, client)
+			FatalOnError(err, "Cannot create consumer for group %s", opts.Kafka.ZipkinConsumerGroupId)
+
+			// spans from the zipkin topic are validated and go through load balancing like the ones received via http
+			callback := func(spans []proxy.Span) {
+				if err := enqueueConsumedSpans(inputQueue, spans, "kafka-zipkin"); err != nil {
+					log.Warnf("Dropping %d spans consumed from zipkin topic: %s", len(spans), err)
+				}
+			}
+			closeZipkinConsumerGroup := balance.ConsumeZipkin(zipkinConsumerGroup,
+				opts.Kafka.ZipkinTopic, opts.Kafka.ZipkinEncoding, callback)
+
+			//noinspection ALL
+			defer closeZipkinConsumerGroup()
+		}
 
 	} else {
+		if opts.Kafka.ZipkinTopic != "" {
+			log.Fatalf("Consuming zipkin topic %s requires a kafka address", opts.Kafka.ZipkinTopic)
+		}
+
 		log.Infof("No kafka load balancing activated, processing spans from http handler only")
 
 		// directly process all input spans
-		go ErrorCorrectSpans(httpInputSpans, processedSpans)
+		go ErrorCorrectSpans(httpInputSpans, processedSpans, skewCorrection)
+	}
+
+	var apiKeys []*apiKey
+	for _, value := range opts.ApiKeys {
+		key, err := parseApiKey(value)
+		FatalOnError(err, "Invalid api key")
+
+		apiKeys = append(apiKeys, key)
+	}
+
+	if len(apiKeys) > 0 {
+		// udp packets do not carry an api key, so they would bypass the authentication
+		if opts.JaegerAgentAddress != "" {
+			log.Fatalf("The jaeger agent udp listener can not be used together with api keys")
+		}
+
+		log.Infof("Require one of %d api keys to accept spans via http and grpc", len(apiKeys))
+	}
+
+	auth := newAuthenticator(apiKeys)
+
+	if opts.OtlpGrpcAddress != "" {
+		log.Infof("Start OTLP grpc server on %s", opts.OtlpGrpcAddress)
+
+		stopGrpcServer, err := serveOtlpGrpc(opts.OtlpGrpcAddress, inputQueue, auth, requestLimits{
+			MaxBodySize: opts.Limits.MaxBodySize,
+		})
+		FatalOnError(err, "Start OTLP grpc server failed")
+
+		defer stopGrpcServer()
+	}
+
+	if opts.JaegerAgentAddress != "" {
+		log.Infof("Start jaeger agent udp listener on %s", opts.JaegerAgentAddress)
+
+		stopUdpListener, err := serveJaegerAgentUdp(opts.JaegerAgentAddress, inputQueue)
+		FatalOnError(err, "Start jaeger agent udp listener failed")
+
+		defer stopUdpListener()
+	}
+
+	var tlsReloader *tlsConfigReloader
+	if opts.HTTP.TLSCertFile != "" && opts.HTTP.TLSKeyFile != "" {
+		var err error
+		tlsReloader, err = newTlsConfigReloader(opts.HTTP.TLSCertFile, opts.HTTP.TLSKeyFile, opts.TLS.ClientCAFile)
+		FatalOnError(err, "Load tls certificate failed")
+
+		if opts.TLS.ReloadInterval > 0 {
+			stopTlsReloader := make(chan struct{})
+			defer close(stopTlsReloader)
+
+			go tlsReloader.Watch(opts.TLS.ReloadInterval, stopTlsReloader)
+		}
+
+		if opts.TLS.ClientCAFile != "" {
+			log.Infof("Require client certificates signed by a ca in %s", opts.TLS.ClientCAFile)
+		}
+
+	} else if opts.TLS.ClientCAFile != "" {
+		log.Fatal("Verifying client certificates requires --http-tls-cert and --http-tls-key")
 	}
 
 	log.Info("Setup completed, starting http listener now")
@@ -163,14 +286,29 @@ func MainWithRouting(routing Routing, spanConverter SpanConverter) {
 	opts.HTTP.Serve(startup_http.Config{
 		Name: "dd-zipkin-proxy",
 
+		RegisterSignalHandlerForServer: func(server *http.Server) <-chan struct{} {
+			if tlsReloader != nil {
+				// always use the most recently loaded certificate
+				server.TLSConfig = &tls.Config{GetConfigForClient: tlsReloader.GetConfigForClient}
+			}
+
+			return startup_http.RegisterSignalHandlerForServer(server)
+		},
+
 		AdminHandlers: []admin.RouteConfig{
 			admin.Describe("A buffer of the previous traces (in openzipkin-format) in the order they were received.",
 				admin.WithGenericValue("/spans", buffer.ToSlice)),
+
+			admin.Describe("The clock skew between services learned from previous traces.",
+				admin.WithGenericValue("/skew", skewCorrection.Estimates)),
 		},
 
 		Routing: func(router *httprouter.Router) http.Handler {
-			handleSpans(router, httpInputSpans)
-			return handleGzipRequestBody(routing(router))
+			handleSpans(router, inputQueue, auth)
+			return handleEncodedRequestBody(routing(router), requestLimits{
+				MaxBodySize:        opts.Limits.MaxBodySize,
+				MaxDecodedBodySize: opts.Limits.MaxDecodedBodySize,
+			})
 		},
 	})
 }
@@ -179,6 +317,14 @@ func toStringPtr(stringValue string) *string {
 	return &stringValue
 }
 
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
 func forwardSpansToChannels(source <-chan proxy.Trace, targets []chan<- proxy.Trace, converter SpanConverter) {
 	processTrace := func(trace proxy.Trace) {
 		// we re-use the same slice for the target and just overwrite
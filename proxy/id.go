@@ -130,3 +130,72 @@ func ParseId(bytes []byte) (Id, error) {
 
 	return result, nil
 }
+
+// A trace id of up to 128 bits. High is zero for 64 bit trace ids.
+type TraceId struct {
+	High Id
+	Low  Id
+}
+
+var _ json.Marshaler = TraceId{}
+var _ json.Unmarshaler = new(TraceId)
+
+func (id TraceId) IsUnknown() bool {
+	return id.High == 0 && id.Low == 0
+}
+
+// Formats the id as 32 hex characters, or as 16 hex characters if only the lower 64 bits are set.
+func (id TraceId) String() string {
+	if id.High == 0 {
+		return id.Low.String()
+	}
+
+	return id.High.String() + id.Low.String()
+}
+
+func (id TraceId) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + id.String() + `"`), nil
+}
+
+func (id *TraceId) UnmarshalJSON(bytes []byte) error {
+	if string(bytes) == "null" {
+		return nil
+	}
+
+	if len(bytes) < 2 || bytes[0] != '"' || bytes[len(bytes)-1] != '"' {
+		return errors.New("expected hex encoded string")
+	}
+
+	parsed, err := ParseTraceId(bytes[1 : len(bytes)-1])
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+
+	return nil
+}
+
+// Parses a hex encoded trace id of up to 32 characters.
+func ParseTraceId(bytes []byte) (TraceId, error) {
+	if len(bytes) > 32 {
+		return TraceId{}, errors.New("hex value too large")
+	}
+
+	if len(bytes) <= 16 {
+		low, err := ParseId(bytes)
+		return TraceId{Low: low}, err
+	}
+
+	high, err := ParseId(bytes[:len(bytes)-16])
+	if err != nil {
+		return TraceId{}, err
+	}
+
+	low, err := ParseId(bytes[len(bytes)-16:])
+	if err != nil {
+		return TraceId{}, err
+	}
+
+	return TraceId{High: high, Low: low}, nil
+}
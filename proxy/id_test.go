@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestParseTraceId(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cases := []struct {
+		input    string
+		expected TraceId
+	}{
+		{input: "beaf", expected: TraceId{Low: 0xbeaf}},
+		{input: "000000000000BEAF", expected: TraceId{Low: 0xbeaf}},
+		{input: "463ac35c9f6413ad", expected: TraceId{Low: 0x463ac35c9f6413ad}},
+		{input: "1000000000000beaf", expected: TraceId{High: 1, Low: 0xbeaf}},
+		{input: "463ac35c9f6413ad48485a3953bb6124", expected: TraceId{High: 0x463ac35c9f6413ad, Low: 0x48485a3953bb6124}},
+		{input: "0000000000000000000000000000beaf", expected: TraceId{Low: 0xbeaf}},
+	}
+
+	for _, c := range cases {
+		parsed, err := ParseTraceId([]byte(c.input))
+		g.Expect(err).ToNot(HaveOccurred(), c.input)
+		g.Expect(parsed).To(Equal(c.expected), c.input)
+	}
+
+	invalid := []string{
+		"463ac35c9f6413ad48485a3953bb61240",
+		"beafx",
+		"463ac35c9f6413ad48485a3953bb612g",
+		"x63ac35c9f6413ad48485a3953bb6124",
+	}
+
+	for _, input := range invalid {
+		_, err := ParseTraceId([]byte(input))
+		g.Expect(err).To(HaveOccurred(), input)
+	}
+}
+
+func TestTraceId_String(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(TraceId{Low: 0xbeaf}.String()).To(Equal("000000000000beaf"))
+	g.Expect(TraceId{High: 1, Low: 0xbeaf}.String()).To(Equal("0000000000000001000000000000beaf"))
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The role of a span in a remote call or in messaging.
+type Kind uint8
+
+const (
+	KindUnspecified Kind = iota
+	KindClient
+	KindServer
+	KindProducer
+	KindConsumer
+)
+
+var kindNames = [...]string{"", "client", "server", "producer", "consumer"}
+
+// Parses the name of a kind ignoring the case, e.g. 'CLIENT' or 'server'.
+// Unknown kinds are returned as KindUnspecified.
+func ParseKind(value string) Kind {
+	for idx, name := range kindNames {
+		if strings.EqualFold(value, name) {
+			return Kind(idx)
+		}
+	}
+
+	return KindUnspecified
+}
+
+func (kind Kind) String() string {
+	if int(kind) < len(kindNames) {
+		return kindNames[kind]
+	}
+
+	return fmt.Sprintf("kind(%d)", kind)
+}
+
+func (kind Kind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+func (kind *Kind) UnmarshalText(text []byte) error {
+	*kind = ParseKind(string(text))
+	return nil
+}
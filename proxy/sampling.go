@@ -0,0 +1,69 @@
+package proxy
+
+// Priority of a trace for sampling, with the same values datadog uses.
+type SamplingPriority int8
+
+const (
+	PriorityUserReject SamplingPriority = -1
+	PriorityAutoReject SamplingPriority = 0
+	PriorityAutoKeep   SamplingPriority = 1
+	PriorityUserKeep   SamplingPriority = 2
+)
+
+// The sampling decision a client made for the trace of a span.
+type Sampling struct {
+	// set if the client made a decision, the priority is only valid in this case
+	Decided  bool             `json:"decided,omitempty"`
+	Priority SamplingPriority `json:"priority,omitempty"`
+
+	// the client asked to keep the span regardless of sampling, like the zipkin debug flag
+	Debug bool `json:"debug,omitempty"`
+}
+
+// Records a decision of a sampler, like the B3 sampled flag.
+func (sampling *Sampling) SetSampled(sampled bool) {
+	if sampled {
+		sampling.SetPriority(PriorityAutoKeep)
+	} else {
+		sampling.SetPriority(PriorityAutoReject)
+	}
+}
+
+func (sampling *Sampling) SetPriority(priority SamplingPriority) {
+	sampling.Decided = true
+	sampling.Priority = priority
+}
+
+// Returns the priority to report for the span. Debug spans are always kept.
+// Returns false if the client did not make a decision.
+func (sampling Sampling) EffectivePriority() (SamplingPriority, bool) {
+	if sampling.Debug {
+		return PriorityUserKeep, true
+	}
+
+	return sampling.Priority, sampling.Decided
+}
+
+// A span is sampled, unless the client decided to drop it.
+func (sampling Sampling) IsSampled() bool {
+	priority, decided := sampling.EffectivePriority()
+	return !decided || priority > PriorityAutoReject
+}
+
+// Combines the decisions of two sides of a span. A decision made
+// by the user is preferred over an automatic one.
+func (sampling Sampling) Merge(other Sampling) Sampling {
+	result := sampling
+	result.Debug = sampling.Debug || other.Debug
+
+	if other.Decided && (!sampling.Decided || other.isUserDecision() && !sampling.isUserDecision()) {
+		result.Decided = true
+		result.Priority = other.Priority
+	}
+
+	return result
+}
+
+func (sampling Sampling) isUserDecision() bool {
+	return sampling.Priority == PriorityUserKeep || sampling.Priority == PriorityUserReject
+}
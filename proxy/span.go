@@ -9,17 +9,81 @@ type Span struct {
 	Parent Id `json:"parent"`
 	Trace  Id `json:"trace"`
 
+	// upper 64 bits of a 128 bit trace id, zero for 64 bit trace ids
+	TraceHigh Id `json:"traceHigh,omitempty"`
+
 	Name    string `json:"name"`
 	Service string `json:"service"`
+	Kind    Kind   `json:"kind,omitempty"`
+
+	// set on the server half of a zipkin span that shares its id with the client half
+	Shared bool `json:"shared,omitempty"`
+
+	// sampling decision of the client that sent the span
+	Sampling Sampling `json:"sampling"`
+
+	// the network endpoints of this and of the other side of the span, if known
+	LocalEndpoint  *Endpoint `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *Endpoint `json:"remoteEndpoint,omitempty"`
 
 	Timestamp Timestamp     `json:"timestamp"`
 	Duration  time.Duration `json:"duration"`
 
 	Tags map[string]string `json:"tags,omitempty"`
 
+	// numeric values of tags, so they do not lose their type. The tags contain them as text too.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+
+	// timestamped events like zipkin annotations, jaeger logs or opentelemetry events
+	Events []Event `json:"events,omitempty"`
+
+	// references to other spans besides the parent, possibly in other traces
+	Links []Link `json:"links,omitempty"`
+
 	Timings Timings `json:"timings"`
 }
 
+// The network endpoint of one side of a span.
+type Endpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+// Returns the ip address of the endpoint, preferring ipv4 over ipv6.
+func (endpoint *Endpoint) Host() string {
+	if endpoint.IPv4 != "" {
+		return endpoint.IPv4
+	}
+
+	return endpoint.IPv6
+}
+
+// Something that happened at a point in time during a span.
+type Event struct {
+	Timestamp Timestamp         `json:"timestamp"`
+	Name      string            `json:"name"`
+	Tags      map[string]string `json:"tags,omitempty"`
+
+	// set for events of the server half of a merged span. The timestamp
+	// is taken from the clock of the server until the skew is corrected.
+	Server bool `json:"-"`
+}
+
+// A reference to a span that is related to this one, like an opentelemetry
+// span link or a jaeger reference that does not point to the parent.
+type Link struct {
+	Trace TraceId `json:"trace"`
+	Span  Id      `json:"span"`
+
+	// the linked span caused this span, but does not wait for its result,
+	// like a jaeger FOLLOWS_FROM reference.
+	FollowsFrom bool `json:"followsFrom,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
 type Timings struct {
 	CS Timestamp `json:"cs,omitempty"`
 	CR Timestamp `json:"cr,omitempty"`
@@ -40,6 +104,11 @@ func NewRootSpan(name string, trace, id Id) Span {
 	return NewSpan(name, trace, id, trace)
 }
 
+// Returns the full trace id of the span.
+func (span *Span) TraceId() TraceId {
+	return TraceId{High: span.TraceHigh, Low: span.Trace}
+}
+
 func (span *Span) HasParent() bool {
 	return !span.IsRoot()
 }
@@ -56,6 +125,22 @@ func (span *Span) AddTag(key, value string) {
 	span.Tags[key] = value
 }
 
+func (span *Span) AddMetric(key string, value float64) {
+	if span.Metrics == nil {
+		span.Metrics = map[string]float64{}
+	}
+
+	span.Metrics[key] = value
+}
+
+func (span *Span) AddEvent(event Event) {
+	span.Events = append(span.Events, event)
+}
+
+func (span *Span) AddLink(link Link) {
+	span.Links = append(span.Links, link)
+}
+
 func (span *Span) AddTiming(key string, ns Timestamp) {
 	switch key {
 	case "cs":
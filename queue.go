@@ -0,0 +1,130 @@
+package zipkinproxy
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Returned if other requests are still waiting to enqueue their spans.
+var errQueueBusy = errors.New("too many concurrent requests, try again later")
+
+// Returned if the spans are not processed fast enough to make room for new ones.
+var errQueueFull = errors.New("span processing is falling behind, try again later")
+
+// how often to check for free capacity in the channel
+const queuePollInterval = 5 * time.Millisecond
+
+// Puts spans into the channel of the processing pipeline. The spans of one batch
+// are enqueued either completely or not at all. If this is not possible within
+// the given timeout, the batch is rejected. Batches larger than the channel could
+// never be enqueued as a whole and exceed the span limit.
+type spanQueue struct {
+	spans   chan<- proxy.Span
+	timeout time.Duration
+
+	// maximum number of spans in one batch, zero if not limited
+	maxSpans int
+
+	// only one batch is enqueued at a time, so that no one can
+	// take away the capacity we were waiting for.
+	lock chan struct{}
+}
+
+func newSpanQueue(spans chan<- proxy.Span, timeout time.Duration, maxSpans int) *spanQueue {
+	return &spanQueue{
+		spans:    spans,
+		timeout:  timeout,
+		maxSpans: maxSpans,
+		lock:     make(chan struct{}, 1),
+	}
+}
+
+func (queue *spanQueue) Enqueue(spans []proxy.Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	if err := queue.CheckSpanCount(len(spans)); err != nil {
+		return err
+	}
+
+	deadline := time.NewTimer(queue.timeout)
+	defer deadline.Stop()
+
+	select {
+	case queue.lock <- struct{}{}:
+		defer func() { <-queue.lock }()
+
+	case <-deadline.C:
+		markRejected("busy", len(spans))
+		return errQueueBusy
+	}
+
+	for cap(queue.spans)-len(queue.spans) < len(spans) {
+		select {
+		case <-time.After(queuePollInterval):
+		case <-deadline.C:
+			markRejected("full", len(spans))
+			return errQueueFull
+		}
+	}
+
+	// we hold the lock, so the capacity can not be taken away and none of the sends blocks.
+	for _, span := range spans {
+		queue.spans <- span
+	}
+
+	return nil
+}
+
+// Checks if a request with the given number of spans is within the limit.
+func (queue *spanQueue) CheckSpanCount(spanCount int) error {
+	if queue.maxSpans > 0 && spanCount > queue.maxSpans {
+		return newTooManySpansError(spanCount, queue.maxSpans)
+	}
+
+	if spanCount > cap(queue.spans) {
+		return newTooManySpansError(spanCount, cap(queue.spans))
+	}
+
+	return nil
+}
+
+// Seconds a client should wait before retrying a rejected request.
+func (queue *spanQueue) RetryAfter() string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(queue.timeout.Seconds()))))
+}
+
+func markRejected(reason string, spanCount int) {
+	metrics.GetOrRegisterMeter("spans.rejected.batches[reason:"+reason+"]", nil).Mark(1)
+	metrics.GetOrRegisterMeter("spans.rejected[reason:"+reason+"]", nil).Mark(int64(spanCount))
+}
+
+// Writes an error response for a failed request. Rejected requests are answered
+// with 429 or 503 so clients retry them later, requests exceeding a limit with 413.
+// All other errors are the fault of the client.
+func writeSpansError(writer http.ResponseWriter, req *http.Request, queue *spanQueue, err error) {
+	if errLimit, ok := errors.Cause(err).(*limitExceededError); ok {
+		markLimitExceeded(req, errLimit)
+		http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	switch errors.Cause(err) {
+	case errQueueBusy:
+		writer.Header().Set("Retry-After", queue.RetryAfter())
+		http.Error(writer, err.Error(), http.StatusTooManyRequests)
+
+	case errQueueFull:
+		writer.Header().Set("Retry-After", queue.RetryAfter())
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+
+	default:
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,104 @@
+package zipkinproxy
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpanQueue_Enqueue(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 4)
+	queue := newSpanQueue(spans, 20*time.Millisecond, 0)
+
+	g.Expect(queue.Enqueue(make([]proxy.Span, 3))).To(Succeed())
+	g.Expect(spans).To(HaveLen(3))
+
+	// does not fit, nothing must be enqueued
+	g.Expect(queue.Enqueue(make([]proxy.Span, 2))).To(Equal(errQueueFull))
+	g.Expect(spans).To(HaveLen(3))
+
+	// fits once the channel drains
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-spans
+	}()
+
+	g.Expect(queue.Enqueue(make([]proxy.Span, 2))).To(Succeed())
+	g.Expect(spans).To(HaveLen(4))
+}
+
+func TestSpanQueue_EnqueueBusy(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	queue := newSpanQueue(make(chan proxy.Span, 4), 20*time.Millisecond, 0)
+
+	// simulate another request that currently enqueues its spans
+	queue.lock <- struct{}{}
+
+	g.Expect(queue.Enqueue(make([]proxy.Span, 1))).To(Equal(errQueueBusy))
+}
+
+func TestSpanQueue_EnqueueLargeBatch(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 2)
+	queue := newSpanQueue(spans, 20*time.Millisecond, 0)
+
+	go func() {
+		for range spans {
+		}
+	}()
+
+	// batches larger than the channel can never be enqueued as a whole
+	err := queue.Enqueue(make([]proxy.Span, 10))
+	g.Expect(err).To(BeAssignableToTypeOf(&limitExceededError{}))
+	g.Expect(queue.CheckSpanCount(10)).To(HaveOccurred())
+	g.Expect(queue.CheckSpanCount(2)).To(Succeed())
+}
+
+func TestSpanQueue_EnqueueRejectedLeavesNothing(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	spans := make(chan proxy.Span, 4)
+	queue := newSpanQueue(spans, 20*time.Millisecond, 0)
+
+	g.Expect(queue.Enqueue(make([]proxy.Span, 2))).To(Succeed())
+
+	// neither a batch that does not fit nor one larger than the channel leaves any spans behind
+	g.Expect(queue.Enqueue(make([]proxy.Span, 3))).To(Equal(errQueueFull))
+	g.Expect(queue.Enqueue(make([]proxy.Span, 5))).To(HaveOccurred())
+	g.Expect(spans).To(HaveLen(2))
+
+	// the lock is released, so other requests are not blocked
+	g.Expect(queue.lock).To(BeEmpty())
+}
+
+func TestWriteSpansError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	queue := newSpanQueue(nil, 1500*time.Millisecond, 0)
+
+	write := func(err error) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		writeSpansError(recorder, httptest.NewRequest("POST", "/api/v2/spans", nil), queue, err)
+		return recorder
+	}
+
+	busy := write(errQueueBusy)
+	g.Expect(busy.Code).To(Equal(http.StatusTooManyRequests))
+	g.Expect(busy.Header().Get("Retry-After")).To(Equal("2"))
+
+	full := write(errors.WithMessage(errQueueFull, "wrapped"))
+	g.Expect(full.Code).To(Equal(http.StatusServiceUnavailable))
+	g.Expect(full.Header().Get("Retry-After")).To(Equal("2"))
+
+	invalid := write(errors.New("invalid json"))
+	g.Expect(invalid.Code).To(Equal(http.StatusBadRequest))
+	g.Expect(invalid.Header().Get("Retry-After")).To(BeEmpty())
+}
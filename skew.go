@@ -0,0 +1,300 @@
+package zipkinproxy
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Wildcard that matches any service in a ServicePair of the SkewCorrection.
+const anyService = "*"
+
+// Weight of a new sample in the running estimate of the skew between two services.
+const skewEstimateWeight = 0.1
+
+// Maximum number of service pairs we keep an estimate for. The service names are sent
+// by the clients, so the least recently updated estimate is dropped to make room.
+const maxSkewEstimates = 1024
+
+// Estimates the clock skew between the client and the server side of a call.
+// The timings of each side are taken from the clock of that side. The result is the
+// duration to add to the timestamps of the server to move them into the client time.
+type SkewCorrector interface {
+	Skew(timings proxy.Timings) time.Duration
+}
+
+// Centers the server span within the client span, assuming that
+// the latency of the request and the response are the same.
+type midpointSkewCorrector struct{}
+
+func (midpointSkewCorrector) Skew(timings proxy.Timings) time.Duration {
+	//        _________________________
+	//       |_cs________|_____________| cr
+	//                   |
+	//                   |--| <-  (cr+cs)/2 - (ss+sr)/2. If the server is right of the client, this difference is
+	//                      |     negative. We need to add the difference to the server time
+	//                      |     to get the corrected time in "client time" under the idea that the
+	//                      |     server span is centered in respect to the client span.
+	//            __________|__________
+	//           |_sr_______|__________| ss
+
+	return time.Duration((timings.CR+timings.CS)/2 - (timings.SR+timings.SS)/2)
+}
+
+// Corrects the skew the way zipkin does. The server span is only moved if it is not within
+// the client span, so the latencies of a plausible call are kept, even if they differ.
+// Otherwise the one-way latency is taken as half of the time the client waited longer than
+// the server worked. Nothing is corrected if the server span is longer than the client span.
+type minLatencySkewCorrector struct{}
+
+func (minLatencySkewCorrector) Skew(timings proxy.Timings) time.Duration {
+	clientDuration := timings.CR - timings.CS
+	serverDuration := timings.SS - timings.SR
+
+	if serverDuration > clientDuration {
+		return 0
+	}
+
+	// there is only skew if the server received before the client sent, or
+	// if the server sent its response after the client received it.
+	if timings.CS < timings.SR && timings.SS < timings.CR {
+		return 0
+	}
+
+	latency := (clientDuration - serverDuration) / 2
+	return time.Duration(timings.CS + latency - timings.SR)
+}
+
+// Measures the clock skew of a call independent of the configured SkewCorrector,
+// assuming the same latency for the request and the response.
+func measureSkew(timings proxy.Timings) time.Duration {
+	return midpointSkewCorrector{}.Skew(timings)
+}
+
+// Does not correct anything, e.g. for services with a reliable clock.
+type noopSkewCorrector struct{}
+
+func (noopSkewCorrector) Skew(proxy.Timings) time.Duration {
+	return 0
+}
+
+// Returns the SkewCorrector with the given name as used in the configuration.
+func skewCorrectorByName(name string) (SkewCorrector, error) {
+	switch name {
+	case "midpoint":
+		return midpointSkewCorrector{}, nil
+
+	case "min-latency":
+		return minLatencySkewCorrector{}, nil
+
+	case "none":
+		return noopSkewCorrector{}, nil
+
+	default:
+		return nil, errors.Errorf("unknown skew correction %q, expected midpoint, min-latency or none", name)
+	}
+}
+
+// The services on both sides of a call.
+type ServicePair struct {
+	Client string
+	Server string
+}
+
+func (pair ServicePair) String() string {
+	return pair.Client + ":" + pair.Server
+}
+
+// Selects the SkewCorrector for a call between two services and learns
+// the skew between services across traces.
+type SkewCorrection struct {
+	// used if no corrector is configured for the services
+	Default SkewCorrector
+
+	// correctors for calls between two services. Either side can be '*' to match any service.
+	Services map[ServicePair]SkewCorrector
+
+	// a meter is marked for services with a larger estimated skew. Disabled if zero.
+	Threshold time.Duration
+
+	lock      sync.Mutex
+	estimates map[ServicePair]*skewEstimate
+}
+
+// The running estimate of the clock skew between two services.
+type skewEstimate struct {
+	// in nanoseconds, as a float to not lose small samples
+	skew    float64
+	samples int
+	updated time.Time
+}
+
+// The estimated skew between two services, as shown on the admin page.
+type SkewEstimate struct {
+	Client     string    `json:"client"`
+	Server     string    `json:"server"`
+	SkewMillis float64   `json:"skewMillis"`
+	Samples    int       `json:"samples"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Parses the default corrector and the correctors for service pairs, given in the
+// format 'client:server=corrector'.
+func parseSkewCorrection(defaultName string, services []string) (*SkewCorrection, error) {
+	defaultCorrector, err := skewCorrectorByName(defaultName)
+	if err != nil {
+		return nil, err
+	}
+
+	correction := &SkewCorrection{
+		Default:  defaultCorrector,
+		Services: map[ServicePair]SkewCorrector{},
+	}
+
+	for _, value := range services {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("expected skew correction in format client:server=corrector, got %q", value)
+		}
+
+		services := strings.SplitN(parts[0], ":", 2)
+		if len(services) != 2 || services[0] == "" || services[1] == "" {
+			return nil, errors.Errorf("expected skew correction in format client:server=corrector, got %q", value)
+		}
+
+		corrector, err := skewCorrectorByName(parts[1])
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse skew correction for "+parts[0])
+		}
+
+		correction.Services[ServicePair{Client: services[0], Server: services[1]}] = corrector
+	}
+
+	return correction, nil
+}
+
+// Returns the corrector for a call between the two services. An exact match is preferred
+// over a wildcard for the server, which is preferred over a wildcard for the client.
+func (correction *SkewCorrection) For(pair ServicePair) SkewCorrector {
+	if correction == nil {
+		return midpointSkewCorrector{}
+	}
+
+	candidates := []ServicePair{
+		pair,
+		{Client: pair.Client, Server: anyService},
+		{Client: anyService, Server: pair.Server},
+	}
+
+	for _, candidate := range candidates {
+		if corrector, ok := correction.Services[candidate]; ok {
+			return corrector
+		}
+	}
+
+	if correction.Default == nil {
+		return midpointSkewCorrector{}
+	}
+
+	return correction.Default
+}
+
+// Adds the skew measured in a trace to the running estimate for the services.
+func (correction *SkewCorrection) Observe(pair ServicePair, skew time.Duration) {
+	if correction == nil || pair.Client == "" || pair.Server == "" || pair.Client == pair.Server {
+		return
+	}
+
+	correction.lock.Lock()
+	defer correction.lock.Unlock()
+
+	if correction.estimates == nil {
+		correction.estimates = map[ServicePair]*skewEstimate{}
+	}
+
+	estimate := correction.estimates[pair]
+	if estimate == nil {
+		if len(correction.estimates) >= maxSkewEstimates {
+			correction.dropOldestEstimate()
+		}
+
+		estimate = &skewEstimate{skew: float64(skew)}
+		correction.estimates[pair] = estimate
+	}
+
+	estimate.skew += skewEstimateWeight * (float64(skew) - estimate.skew)
+	estimate.samples++
+	estimate.updated = time.Now()
+
+	threshold := float64(correction.Threshold)
+	if threshold > 0 && (estimate.skew > threshold || estimate.skew < -threshold) {
+		// the services are shown on the admin page, we do not create a meter for each of them
+		metrics.GetOrRegisterMeter("spans.skew.exceeded", nil).Mark(1)
+	}
+}
+
+// Removes the estimate that was not updated for the longest time. Must be called with the lock held.
+func (correction *SkewCorrection) dropOldestEstimate() {
+	var oldestPair ServicePair
+	var oldest *skewEstimate
+
+	for pair, estimate := range correction.estimates {
+		if oldest == nil || estimate.updated.Before(oldest.updated) {
+			oldestPair, oldest = pair, estimate
+		}
+	}
+
+	delete(correction.estimates, oldestPair)
+}
+
+// Returns the skew learned for the services, or false, if there is none yet.
+func (correction *SkewCorrection) Estimate(pair ServicePair) (time.Duration, bool) {
+	if correction == nil {
+		return 0, false
+	}
+
+	correction.lock.Lock()
+	defer correction.lock.Unlock()
+
+	estimate := correction.estimates[pair]
+	if estimate == nil {
+		return 0, false
+	}
+
+	return time.Duration(estimate.skew), true
+}
+
+// Returns all learned estimates, ordered by client and server.
+func (correction *SkewCorrection) Estimates() []SkewEstimate {
+	if correction == nil {
+		return nil
+	}
+
+	correction.lock.Lock()
+	defer correction.lock.Unlock()
+
+	result := make([]SkewEstimate, 0, len(correction.estimates))
+	for pair, estimate := range correction.estimates {
+		result = append(result, SkewEstimate{
+			Client:     pair.Client,
+			Server:     pair.Server,
+			SkewMillis: estimate.skew / float64(time.Millisecond),
+			Samples:    estimate.samples,
+			Updated:    estimate.updated,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Client != result[j].Client {
+			return result[i].Client < result[j].Client
+		}
+
+		return result[i].Server < result[j].Server
+	})
+
+	return result
+}
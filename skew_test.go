@@ -0,0 +1,132 @@
+package zipkinproxy
+
+import (
+	"github.com/flachnetz/dd-zipkin-proxy/proxy"
+	. "github.com/onsi/gomega"
+	"github.com/rcrowley/go-metrics"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSkewCorrectors(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	ms := proxy.Timestamp(time.Millisecond)
+
+	// the server is one second ahead, both correctors assume the same latency on both sides
+	skewed := proxy.Timings{CS: 100 * ms, CR: 200 * ms, SR: 1110 * ms, SS: 1170 * ms}
+	g.Expect(midpointSkewCorrector{}.Skew(skewed)).To(Equal(-990 * time.Millisecond))
+	g.Expect(minLatencySkewCorrector{}.Skew(skewed)).To(Equal(-990 * time.Millisecond))
+	g.Expect(noopSkewCorrector{}.Skew(skewed)).To(BeZero())
+
+	// the server is slightly behind, it is moved to leave the same latency on both sides
+	behind := proxy.Timings{CS: 100 * ms, CR: 200 * ms, SR: 90 * ms, SS: 150 * ms}
+	g.Expect(minLatencySkewCorrector{}.Skew(behind)).To(Equal(30 * time.Millisecond))
+
+	// a server within the client is not moved
+	plausible := proxy.Timings{CS: 100 * ms, CR: 200 * ms, SR: 180 * ms, SS: 190 * ms}
+	g.Expect(midpointSkewCorrector{}.Skew(plausible)).To(Equal(-35 * time.Millisecond))
+	g.Expect(minLatencySkewCorrector{}.Skew(plausible)).To(BeZero())
+
+	// a server longer than the client can not be fixed by moving it
+	longer := proxy.Timings{CS: 100 * ms, CR: 200 * ms, SR: 1000 * ms, SS: 1200 * ms}
+	g.Expect(minLatencySkewCorrector{}.Skew(longer)).To(BeZero())
+}
+
+func TestParseSkewCorrection(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	correction, err := parseSkewCorrection("midpoint", []string{
+		"frontend:backend=none",
+		"frontend:*=min-latency",
+		"*:database=none",
+	})
+
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(correction.For(ServicePair{Client: "frontend", Server: "backend"})).To(Equal(noopSkewCorrector{}))
+	g.Expect(correction.For(ServicePair{Client: "frontend", Server: "database"})).To(Equal(minLatencySkewCorrector{}))
+	g.Expect(correction.For(ServicePair{Client: "backend", Server: "database"})).To(Equal(noopSkewCorrector{}))
+	g.Expect(correction.For(ServicePair{Client: "backend", Server: "frontend"})).To(Equal(midpointSkewCorrector{}))
+
+	// without any configuration, the server is centered in the client
+	var noCorrection *SkewCorrection
+	g.Expect(noCorrection.For(ServicePair{Client: "frontend", Server: "backend"})).To(Equal(midpointSkewCorrector{}))
+
+	_, err = parseSkewCorrection("center", nil)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = parseSkewCorrection("midpoint", []string{"frontend=none"})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = parseSkewCorrection("midpoint", []string{"frontend:backend"})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = parseSkewCorrection("midpoint", []string{"frontend:backend=center"})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestSkewCorrection_Estimates(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	correction := &SkewCorrection{Threshold: 500 * time.Millisecond}
+
+	pair := ServicePair{Client: "frontend", Server: "backend"}
+
+	exceeded := metrics.GetOrRegisterMeter("spans.skew.exceeded", nil)
+	exceededBefore := exceeded.Count()
+
+	_, ok := correction.Estimate(pair)
+	g.Expect(ok).To(BeFalse())
+
+	// the first sample is taken as is, later ones only move the estimate a bit
+	correction.Observe(pair, -time.Second)
+	correction.Observe(pair, 0)
+
+	skew, ok := correction.Estimate(pair)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(skew).To(Equal(-900 * time.Millisecond))
+
+	// skew within a service or to an unknown service is not learned
+	correction.Observe(ServicePair{Client: "backend", Server: "backend"}, time.Second)
+	correction.Observe(ServicePair{Client: "", Server: "backend"}, time.Second)
+	correction.Observe(ServicePair{Client: "backend", Server: "database"}, 10*time.Millisecond)
+
+	estimates := correction.Estimates()
+	g.Expect(estimates).To(HaveLen(2))
+	g.Expect(estimates[0].Client).To(Equal("backend"))
+	g.Expect(estimates[0].SkewMillis).To(BeNumerically("~", 10, 0.001))
+	g.Expect(estimates[1].Client).To(Equal("frontend"))
+	g.Expect(estimates[1].SkewMillis).To(BeNumerically("~", -900, 0.001))
+	g.Expect(estimates[1].Samples).To(Equal(2))
+
+	// only the services with a large skew are reported
+	g.Expect(exceeded.Count() - exceededBefore).To(Equal(int64(2)))
+}
+
+func TestSkewCorrection_EstimatesLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	correction := &SkewCorrection{}
+
+	pairOf := func(idx int) ServicePair {
+		return ServicePair{Client: "client-" + strconv.Itoa(idx), Server: "server"}
+	}
+
+	for idx := 0; idx < maxSkewEstimates; idx++ {
+		correction.Observe(pairOf(idx), time.Second)
+	}
+
+	// the estimate that was not updated for the longest time is dropped first
+	correction.estimates[pairOf(5)].updated = time.Time{}
+
+	correction.Observe(pairOf(maxSkewEstimates), time.Second)
+	g.Expect(correction.Estimates()).To(HaveLen(maxSkewEstimates))
+
+	_, ok := correction.Estimate(pairOf(5))
+	g.Expect(ok).To(BeFalse())
+
+	_, ok = correction.Estimate(pairOf(maxSkewEstimates))
+	g.Expect(ok).To(BeTrue())
+}
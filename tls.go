@@ -0,0 +1,145 @@
+package zipkinproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Keeps the tls configuration of the http server up to date with the
+// certificate files on disk, so certificates can be rotated without a restart.
+type tlsConfigReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	// the current *tls.Config
+	config atomic.Value
+
+	// size and modification time of the files of the current config
+	fingerprint string
+}
+
+func newTlsConfigReloader(certFile, keyFile, clientCAFile string) (*tlsConfigReloader, error) {
+	reloader := &tlsConfigReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *tlsConfigReloader) files() []string {
+	files := []string{reloader.certFile, reloader.keyFile}
+	if reloader.clientCAFile != "" {
+		files = append(files, reloader.clientCAFile)
+	}
+
+	return files
+}
+
+func (reloader *tlsConfigReloader) currentFingerprint() (string, error) {
+	var fingerprint string
+
+	for _, file := range reloader.files() {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+
+		fingerprint += file + ":" + strconv.FormatInt(stat.Size(), 10) + ":" + stat.ModTime().String() + ";"
+	}
+
+	return fingerprint, nil
+}
+
+func (reloader *tlsConfigReloader) load() error {
+	fingerprint, err := reloader.currentFingerprint()
+	if err != nil {
+		return errors.WithMessage(err, "check certificate files")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return errors.WithMessage(err, "load certificate")
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if reloader.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(reloader.clientCAFile)
+		if err != nil {
+			return errors.WithMessage(err, "read client ca bundle")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificates found in client ca bundle %s", reloader.clientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	reloader.config.Store(config)
+	reloader.fingerprint = fingerprint
+
+	return nil
+}
+
+// Reloads the configuration if one of the files has changed. If the new files are
+// broken, the previous configuration stays active.
+func (reloader *tlsConfigReloader) reloadIfChanged() (bool, error) {
+	fingerprint, err := reloader.currentFingerprint()
+	if err != nil || fingerprint == reloader.fingerprint {
+		return false, err
+	}
+
+	if err := reloader.load(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Checks for changed files in the given interval until the stop channel is closed.
+func (reloader *tlsConfigReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := reloader.reloadIfChanged()
+			if err != nil {
+				log.Warnf("Could not reload tls certificate, keeping the previous one: %s", err)
+			}
+
+			if reloaded {
+				log.Infof("Reloaded tls certificate from %s", reloader.certFile)
+			}
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// To be used as tls.Config.GetConfigForClient of the server.
+func (reloader *tlsConfigReloader) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return reloader.config.Load().(*tls.Config), nil
+}
@@ -0,0 +1,195 @@
+package zipkinproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// Creates a certificate signed by the parent, or a self signed ca if parent is nil.
+func newTestCertificate(g *GomegaWithT, name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (cert *testCertificate) Write(g *GomegaWithT, certFile, keyFile string) {
+	g.Expect(ioutil.WriteFile(certFile, cert.certPEM, 0600)).To(Succeed())
+	g.Expect(ioutil.WriteFile(keyFile, cert.keyPEM, 0600)).To(Succeed())
+}
+
+func (cert *testCertificate) KeyPair(g *GomegaWithT) tls.Certificate {
+	pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+	g.Expect(err).ToNot(HaveOccurred())
+	return pair
+}
+
+func tempDir(g *GomegaWithT) (string, func()) {
+	dir, err := ioutil.TempDir("", "dd-zipkin-proxy-tls")
+	g.Expect(err).ToNot(HaveOccurred())
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestTlsConfigReloader(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, cleanup := tempDir(g)
+	defer cleanup()
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	ca := newTestCertificate(g, "ca", nil)
+	first := newTestCertificate(g, "first", ca)
+	first.Write(g, certFile, keyFile)
+
+	reloader, err := newTlsConfigReloader(certFile, keyFile, "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	commonName := func() string {
+		config, err := reloader.GetConfigForClient(nil)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		g.Expect(err).ToNot(HaveOccurred())
+		return cert.Subject.CommonName
+	}
+
+	g.Expect(commonName()).To(Equal("first"))
+
+	// nothing changed
+	reloaded, err := reloader.reloadIfChanged()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reloaded).To(BeFalse())
+
+	// a broken certificate keeps the previous config
+	g.Expect(ioutil.WriteFile(certFile, []byte("broken"), 0600)).To(Succeed())
+	reloaded, err = reloader.reloadIfChanged()
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(reloaded).To(BeFalse())
+	g.Expect(commonName()).To(Equal("first"))
+
+	// rotate to a new certificate
+	second := newTestCertificate(g, "second", ca)
+	second.Write(g, certFile, keyFile)
+
+	later := time.Now().Add(time.Minute)
+	g.Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+
+	reloaded, err = reloader.reloadIfChanged()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reloaded).To(BeTrue())
+
+	g.Expect(commonName()).To(Equal("second"))
+}
+
+func TestTlsClientCertificates(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, cleanup := tempDir(g)
+	defer cleanup()
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca := newTestCertificate(g, "ca", nil)
+	newTestCertificate(g, "server", ca).Write(g, certFile, keyFile)
+	g.Expect(ioutil.WriteFile(caFile, ca.certPEM, 0600)).To(Succeed())
+
+	reloader, err := newTlsConfigReloader(certFile, keyFile, caFile)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.WriteHeader(http.StatusAccepted)
+	}))
+
+	server.TLS = &tls.Config{GetConfigForClient: reloader.GetConfigForClient}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	request := func(certificates ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+			},
+		}
+
+		return client.Get(server.URL)
+	}
+
+	// client without a certificate
+	_, err = request()
+	g.Expect(err).To(HaveOccurred())
+
+	// client with a certificate from an unknown ca
+	otherCA := newTestCertificate(g, "other-ca", nil)
+	_, err = request(newTestCertificate(g, "client", otherCA).KeyPair(g))
+	g.Expect(err).To(HaveOccurred())
+
+	// client with a valid certificate
+	response, err := request(newTestCertificate(g, "client", ca).KeyPair(g))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer response.Body.Close()
+
+	g.Expect(response.StatusCode).To(Equal(http.StatusAccepted))
+}
@@ -0,0 +1,64 @@
+package zipkinproxy
+
+import (
+	"bytes"
+	"context"
+	"github.com/flachnetz/dd-zipkin-proxy/codec"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"net"
+)
+
+// jaeger clients never send packets larger than this
+const maxJaegerPacketSize = 65000
+
+// Listens for emitBatch calls of jaeger clients on the given udp address and puts the
+// spans into the provided queue. The returned function stops the listener.
+func serveJaegerAgentUdp(address string, queue *spanQueue) (func(), error) {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, errors.WithMessage(err, "resolve udp address")
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddress)
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen for udp packets")
+	}
+
+	// give us some room if we can not keep up for a short moment
+	if err := conn.SetReadBuffer(4 * 1024 * 1024); err != nil {
+		log.Warnf("Could not increase udp read buffer: %s", err)
+	}
+
+	stopCh := make(chan struct{})
+
+	go func() {
+		buf := make([]byte, maxJaegerPacketSize)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				select {
+				case <-stopCh:
+					return
+				default:
+					log.Warnf("Could not read jaeger udp packet: %s", err)
+					continue
+				}
+			}
+
+			metrics.GetOrRegisterTimer("spans.receive[type:jaeger-thrift-udp]", nil).Time(func() {
+				_, err = parseSpans(context.Background(), queue, bytes.NewReader(buf[:n]), "thrift", codec.ParseJaegerThriftCompact)
+			})
+
+			if err != nil {
+				log.Warnf("Could not parse jaeger udp packet: %s", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		_ = conn.Close()
+	}, nil
+}